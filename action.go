@@ -1,7 +1,15 @@
 package peco
 
 import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/nsf/termbox-go"
 	"github.com/peco/peco/keyseq"
@@ -26,6 +34,27 @@ var nameToActions map[string]Action
 // This is the default keybinding used by NewKeymap()
 var defaultKeyBinding map[string]Action
 
+// ParamActionFunc is like ActionFunc, but takes an argument, which is
+// specified along with the name of the action, e.g. "peco.ExportAs(git)"
+type ParamActionFunc func(*Input, termbox.Event, string)
+
+// This is the global map of canonical action name to actions that
+// take an argument
+var nameToParamActions map[string]ParamActionFunc
+
+// Register registers `a` into the global registry of actions that
+// take an argument by the name `name`
+func (a ParamActionFunc) Register(name string) {
+	nameToParamActions["peco."+name] = a
+}
+
+// Bind returns an Action that calls `a` with `arg`
+func (a ParamActionFunc) Bind(arg string) Action {
+	return ActionFunc(func(i *Input, ev termbox.Event) {
+		a(i, ev, arg)
+	})
+}
+
 // Execute fulfills the Action interface for AfterFunc
 func (a ActionFunc) Execute(i *Input, e termbox.Event) {
 	a(i, e)
@@ -50,6 +79,7 @@ func (a ActionFunc) RegisterKeySequence(k keyseq.KeyList) {
 func init() {
 	// Build the global maps
 	nameToActions = map[string]Action{}
+	nameToParamActions = map[string]ParamActionFunc{}
 	defaultKeyBinding = map[string]Action{}
 
 	ActionFunc(doBeginningOfLine).Register("BeginningOfLine", termbox.KeyCtrlA)
@@ -68,6 +98,7 @@ func init() {
 	)
 	ActionFunc(doDeleteForwardChar).Register("DeleteForwardChar", termbox.KeyCtrlD)
 	ActionFunc(doDeleteForwardWord).Register("DeleteForwardWord")
+	ActionFunc(doTransposeChars).Register("TransposeChars", termbox.KeyCtrlT)
 	ActionFunc(doEndOfFile).Register("EndOfFile")
 	ActionFunc(doEndOfLine).Register("EndOfLine", termbox.KeyCtrlE)
 	ActionFunc(doFinish).Register("Finish", termbox.KeyEnter)
@@ -76,6 +107,7 @@ func init() {
 	ActionFunc(doKillEndOfLine).Register("KillEndOfLine", termbox.KeyCtrlK)
 	ActionFunc(doKillBeginningOfLine).Register("KillBeginningOfLine", termbox.KeyCtrlU)
 	ActionFunc(doRotateMatcher).Register("RotateMatcher", termbox.KeyCtrlR)
+	ActionFunc(doToggleRegexp).Register("ToggleRegexp")
 	ActionFunc(doSelectNext).Register(
 		"SelectNext",
 		termbox.KeyArrowDown,
@@ -106,6 +138,8 @@ func init() {
 	)
 	ActionFunc(doSelectAll).Register("SelectAll")
 	ActionFunc(doSelectVisible).Register("SelectVisible")
+	ActionFunc(doSelectSimilar).Register("SelectSimilar")
+	ActionFunc(doCaptureKey).Register("CaptureKey")
 	ActionFunc(func(i *Input, ev termbox.Event) {
 		i.SendStatusMsg("ToggleSelectMode is deprecated. Use ToggleRangeMode")
 		doToggleRangeMode(i, ev)
@@ -116,6 +150,66 @@ func init() {
 	}).Register("CancelSelectMode")
 	ActionFunc(doToggleRangeMode).Register("ToggleRangeMode")
 	ActionFunc(doCancelRangeMode).Register("CancelRangeMode")
+	ActionFunc(doAcceptInIndexOrder).Register("AcceptInIndexOrder")
+	ActionFunc(doAcceptAndContinue).Register("AcceptAndContinue")
+	ActionFunc(doStartVisualSelect).Register("StartVisualSelect")
+	ActionFunc(doEndVisualSelect).Register("EndVisualSelect")
+	ActionFunc(doExecuteCommand).Register("ExecuteCommand")
+	ActionFunc(doOpenInPane).Register("OpenInPane")
+	ActionFunc(doForEachSelection).Register("ForEachSelection")
+	ActionFunc(doPipeSelection).Register("PipeSelection")
+	ActionFunc(doRepeatLastCommand).Register("RepeatLastCommand")
+	ActionFunc(doCopyToClipboard).Register("CopyToClipboard")
+	ActionFunc(doCopyToTmuxBuffer).Register("CopyToTmuxBuffer")
+	ActionFunc(doCopyView).Register("CopyView")
+	ActionFunc(doCopyRelativePath).Register("CopyRelativePath")
+	ParamActionFunc(doExportAs).Register("ExportAs")
+	ParamActionFunc(doBookmarkSelection).Register("BookmarkSelection")
+	ParamActionFunc(combineBookmarkAction(Selection.Difference)).Register("SelectionDiff")
+	ParamActionFunc(combineBookmarkAction(Selection.Union)).Register("SelectionUnion")
+	ParamActionFunc(combineBookmarkAction(Selection.Intersection)).Register("SelectionIntersect")
+	ActionFunc(doDrillDown).Register("DrillDown")
+	ActionFunc(doLoadShellHistory).Register("LoadShellHistory")
+	ActionFunc(doDrillUp).Register("DrillUp")
+	ActionFunc(doRefineToSelection).Register("RefineToSelection")
+	ActionFunc(doCommandPalette).Register("CommandPalette")
+	ActionFunc(func(i *Input, ev termbox.Event) {
+		doEnterPreview(i, ev, EnterPreviewQuery)
+	}).Register("EnterPreview")
+	ParamActionFunc(doEnterPreview).Register("EnterPreview")
+	ActionFunc(doNextSelection).Register("NextSelection")
+	ActionFunc(doPreviousSelection).Register("PreviousSelection")
+	ActionFunc(doToggleRelativeNumbers).Register("ToggleRelativeNumbers")
+	ActionFunc(doFlashMatches).Register("FlashMatches")
+	ActionFunc(doToggleViewportScope).Register("ToggleViewportScope")
+	ActionFunc(doToggleInfoLine).Register("ToggleInfoLine")
+	ActionFunc(doToggleCompact).Register("ToggleCompact")
+	ActionFunc(doLockResults).Register("LockResults")
+	ActionFunc(doToggleMatchDescription).Register("ToggleMatchDescription")
+	ActionFunc(doToggleFullLineHighlight).Register("ToggleFullLineHighlight")
+	ActionFunc(doTogglePreviewOutput).Register("TogglePreviewOutput")
+	ActionFunc(doTogglePreview).Register("TogglePreview")
+	ActionFunc(doPreviewToMatch).Register("PreviewToMatch")
+	ActionFunc(doFocusPreview).Register("FocusPreview")
+	ActionFunc(doFocusList).Register("FocusList")
+	ActionFunc(doToggleFocus).Register("ToggleFocus")
+	ActionFunc(doFilterByExtension).Register("FilterByExtension")
+	ActionFunc(doFilterBySiblingPrefix).Register("FilterBySiblingPrefix")
+	ActionFunc(doIsolateTerm).Register("IsolateTerm")
+	ActionFunc(doCompleteQuery).Register("CompleteQuery")
+	ActionFunc(func(i *Input, ev termbox.Event) {
+		doSeedQueryFromLine(i, ev, "")
+	}).Register("SeedQueryFromLine")
+	ParamActionFunc(doSeedQueryFromLine).Register("SeedQueryFromLine")
+	ParamActionFunc(doLoadNamedQuery).Register("LoadNamedQuery")
+	ActionFunc(doIncrementNumber).Register("IncrementNumber")
+	ActionFunc(doDecrementNumber).Register("DecrementNumber")
+	ActionFunc(doToggleLastMatcher).Register("ToggleLastMatcher")
+	ActionFunc(doCycleSortColumn).Register("CycleSortColumn")
+	ActionFunc(doSaveConfig).Register("SaveConfig")
+	ActionFunc(doPinLine).Register("PinLine")
+	ActionFunc(doUnpinLine).Register("UnpinLine")
+	ActionFunc(doToggleSortDirection).Register("ToggleSortDirection")
 
 	ActionFunc(doKonamiCommand).RegisterKeySequence(
 		keyseq.KeyList{
@@ -145,6 +239,11 @@ func doAcceptChar(i *Input, ev termbox.Event) {
 	}
 
 	if ev.Ch > 0 {
+		if max := i.config.MaxQueryLength; max > 0 && len(i.query) >= max {
+			i.SendStatusMsg(fmt.Sprintf("Query is too long (max %d characters)", max))
+			return
+		}
+
 		if len(i.query) == i.caretPos {
 			i.query = append(i.query, ev.Ch)
 		} else {
@@ -160,30 +259,468 @@ func doAcceptChar(i *Input, ev termbox.Event) {
 }
 
 func doRotateMatcher(i *Input, ev termbox.Event) {
-	i.Ctx.CurrentMatcher++
-	if i.Ctx.CurrentMatcher >= len(i.Ctx.Matchers) {
-		i.Ctx.CurrentMatcher = 0
+	next := i.Ctx.CurrentMatcher + 1
+	if next >= len(i.Ctx.Matchers) {
+		next = 0
+	}
+	i.switchMatcher(next)
+	if i.ExecQuery() {
+		return
+	}
+	i.DrawMatches(nil)
+}
+
+func doToggleRegexp(i *Input, ev termbox.Event) {
+	re := i.matcherIndex(RegexpMatch)
+	if re < 0 {
+		return
+	}
+
+	if i.CurrentMatcher == re {
+		i.switchMatcher(i.literalMatcher)
+	} else {
+		// Make sure the query is a valid regular expression before
+		// switching, otherwise we would just display nothing
+		if m, ok := i.Matchers[re].(*RegexpMatcher); ok && len(i.query) > 0 {
+			if _, err := m.queryToRegexps(string(i.query)); err != nil {
+				i.SendStatusMsg(fmt.Sprintf("Invalid regular expression: %s", err))
+				return
+			}
+		}
+		i.literalMatcher = i.CurrentMatcher
+		i.switchMatcher(re)
+	}
+
+	if i.ExecQuery() {
+		return
+	}
+	i.DrawMatches(nil)
+}
+
+// doToggleLastMatcher switches back to the matcher that was used
+// before the current one
+func doToggleLastMatcher(i *Input, ev termbox.Event) {
+	if i.previousMatcher < 0 || i.previousMatcher >= len(i.Matchers) {
+		return
+	}
+
+	i.switchMatcher(i.previousMatcher)
+	if i.ExecQuery() {
+		return
+	}
+	i.DrawMatches(nil)
+}
+
+// doFilterByExtension replaces the query so that only the lines with
+// the same extension as the current line are displayed
+func doFilterByExtension(i *Input, _ termbox.Event) {
+	m := i.CurrentMatch()
+	if m == nil {
+		return
+	}
+
+	line, _ := splitDescription(m.Line(), i.config.DescriptionSeparator)
+	query := extensionQuery(line)
+	if query == "" {
+		i.SendStatusMsg("Current line has no extension")
+		return
+	}
+	filterByRegexp(i, query)
+}
+
+// doFilterBySiblingPrefix replaces the query so that only the lines
+// that share the current line's prefix up to the last SiblingDelimiter
+// are displayed, e.g. "a.b." for "a.b.c"
+func doFilterBySiblingPrefix(i *Input, _ termbox.Event) {
+	m := i.CurrentMatch()
+	if m == nil {
+		return
+	}
+
+	line, _ := splitDescription(m.Line(), i.config.DescriptionSeparator)
+	query := siblingPrefixQuery(line, i.config.SiblingDelimiter)
+	if query == "" {
+		i.SendStatusMsg("Current line has no parent")
+		return
+	}
+	filterByRegexp(i, query)
+}
+
+// filterByRegexp switches to the Regexp matcher, and replaces the
+// query with `query`
+func filterByRegexp(i *Input, query string) {
+	re := i.matcherIndex(RegexpMatch)
+	if re < 0 {
+		return
+	}
+	if i.CurrentMatcher != re {
+		i.literalMatcher = i.CurrentMatcher
+		i.switchMatcher(re)
+	}
+
+	i.SetQuery([]rune(query))
+	i.ExecQuery()
+}
+
+// siblingPrefixQuery returns a regular expression that matches the
+// lines starting with the same prefix as `line`, up to and including
+// the last `delim`. Returns an empty string if `line` has no `delim`
+func siblingPrefixQuery(line, delim string) string {
+	if delim == "" {
+		return ""
+	}
+	k := strings.LastIndex(line, delim)
+	if k < 0 {
+		return ""
 	}
+
+	// Spaces separate query terms, so they can't be used as is
+	prefix := regexp.QuoteMeta(line[:k+len(delim)])
+	return "^" + strings.Replace(prefix, " ", `\x20`, -1)
+}
+
+func doSaveConfig(i *Input, _ termbox.Event) {
+	filename := i.config.SaveConfigPath
+	if filename == "" {
+		i.SendStatusMsg("SaveConfigPath is not set")
+		return
+	}
+
+	if err := i.SaveConfig(filename); err != nil {
+		i.SendStatusMsg(fmt.Sprintf("Failed to save config: %s", err))
+		return
+	}
+	i.SendStatusMsg(fmt.Sprintf("Saved config to %s", filename))
+}
+
+func doPinLine(i *Input, _ termbox.Event) {
+	if m := i.CurrentMatch(); m != nil && !i.IsPinned(m) {
+		i.Pin(m)
+		resortMatches(i)
+	}
+}
+
+func doUnpinLine(i *Input, _ termbox.Event) {
+	if m := i.CurrentMatch(); m != nil && i.IsPinned(m) {
+		i.Unpin(m)
+		resortMatches(i)
+	}
+}
+
+// doCycleSortColumn sorts the lines by the next column of the current
+// line. After the last column, the lines go back to the input order
+func doCycleSortColumn(i *Input, _ termbox.Event) {
+	m := i.CurrentMatch()
+	if m == nil {
+		return
+	}
+
+	columns := len(splitColumns(m.Line(), i.columnDelimiter()))
+	i.sortColumn++
+	if i.sortColumn > columns {
+		i.sortColumn = 0
+	}
+	resortMatches(i)
+}
+
+func doToggleSortDirection(i *Input, _ termbox.Event) {
+	i.sortDescending = !i.sortDescending
+	if i.sortColumn > 0 {
+		resortMatches(i)
+	} else {
+		i.DrawMatches(nil)
+	}
+}
+
+// resortMatches displays the lines in the new order
+func resortMatches(i *Input) {
+	i.currentLine = 1
 	if i.ExecQuery() {
 		return
 	}
+	i.current = nil
+	i.DrawMatches(nil)
+}
+
+// doIsolateTerm replaces the query with the query term that the caret
+// is on, dropping the other terms
+func doIsolateTerm(i *Input, _ termbox.Event) {
+	if i.IsCommandMode() {
+		return
+	}
+
+	term := termAt(i.query, i.caretPos)
+	if term == nil || len(term) == len(i.query) {
+		return
+	}
+
+	i.SetQuery(term)
+	i.ExecQuery()
+}
+
+// termAt returns the query term (i.e. the space separated word) that
+// contains the caret at `pos`, including when the caret is right after
+// it. Returns nil if the caret is not on a term
+func termAt(query []rune, pos int) []rune {
+	if pos > len(query) {
+		pos = len(query)
+	}
+
+	start := pos
+	for start > 0 && query[start-1] != ' ' {
+		start--
+	}
+	end := pos
+	for end < len(query) && query[end] != ' ' {
+		end++
+	}
+
+	if start == end {
+		return nil
+	}
+	term := make([]rune, end-start)
+	copy(term, query[start:end])
+	return term
+}
+
+// doSeedQueryFromLine inserts the current line, or its column `field`
+// (starting from 1) if specified, into the query at the caret. The text
+// is escaped so that the current matcher treats it literally
+func doSeedQueryFromLine(i *Input, _ termbox.Event, field string) {
+	if i.IsCommandMode() {
+		return
+	}
+
+	m := i.CurrentMatch()
+	if m == nil {
+		return
+	}
+
+	text, _ := splitDescription(m.Line(), i.config.DescriptionSeparator)
+	if field != "" {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			i.SendStatusMsg(fmt.Sprintf("Invalid field '%s'", field))
+			return
+		}
+		columns := splitColumns(text, i.columnDelimiter())
+		if n > len(columns) {
+			i.SendStatusMsg(fmt.Sprintf("There's no field %d", n))
+			return
+		}
+		text = strings.TrimSpace(columns[n-1])
+	}
+
+	seed := []rune(escapeQuery(i.Matcher().String(), text))
+	caretPos := i.caretPos + len(seed)
+	q := make([]rune, 0, len(i.query)+len(seed))
+	q = append(q, i.query[:i.caretPos]...)
+	q = append(q, seed...)
+	q = append(q, i.query[i.caretPos:]...)
+	i.SetQuery(q)
+	if caretPos < i.caretPos {
+		// Move the caret to the end of the inserted text, unless
+		// the query was truncated before that
+		i.caretPos = caretPos
+	}
+	if i.ExecQuery() {
+		return
+	}
+	i.current = nil
 	i.DrawMatches(nil)
 }
 
+// escapeQuery escapes `s` so that the matcher named `matcher` matches
+// it literally. Spaces can't be escaped for the matchers that split the
+// query into terms, so each word is matched separately by those
+func escapeQuery(matcher, s string) string {
+	switch matcher {
+	case RegexpMatch:
+		// Spaces separate the terms, even in regular expressions
+		return strings.Replace(regexp.QuoteMeta(s), " ", `\x20`, -1)
+	case LikeMatch:
+		r := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+		return r.Replace(s)
+	}
+	return s
+}
+
+func doCompleteQuery(i *Input, _ termbox.Event) {
+	if i.IsCommandMode() {
+		return
+	}
+
+	targets := i.currentTargets()
+	lines := make([]string, len(targets))
+	for n, m := range targets {
+		lines[n], _ = splitDescription(m.Line(), i.config.DescriptionSeparator)
+	}
+
+	// Only the last term is completed, as the others may be
+	// anywhere in the line
+	terms := strings.Split(string(i.query), " ")
+	completion := completeTerm(terms[len(terms)-1], lines)
+	if completion == "" {
+		i.SendStatusMsg("No completion available")
+		return
+	}
+
+	i.SetQuery(append(i.query, []rune(completion)...))
+	i.ExecQuery()
+}
+
+// completeTerm returns the longest text that follows `term` in all of
+// `lines`, so that appending it to the term matches the same lines.
+// Case is ignored when looking for the term. If `term` is empty, this
+// is the longest common prefix of the lines
+func completeTerm(term string, lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	lower := strings.ToLower(term)
+	var common string
+	for n, line := range lines {
+		pos := 0
+		if term != "" {
+			pos = strings.Index(strings.ToLower(line), lower)
+			if pos < 0 || pos+len(term) > len(line) {
+				return ""
+			}
+			pos += len(term)
+		}
+		rest := line[pos:]
+
+		if n == 0 {
+			common = rest
+			continue
+		}
+		k := 0
+		for k < len(common) && k < len(rest) && common[k] == rest[k] {
+			k++
+		}
+		common = common[:k]
+		if common == "" {
+			return ""
+		}
+	}
+
+	// Don't cut a multibyte character in half
+	for len(common) > 0 && !utf8.ValidString(common) {
+		common = common[:len(common)-1]
+	}
+	return common
+}
+
+func doIncrementNumber(i *Input, _ termbox.Event) {
+	adjustQueryNumber(i, 1)
+}
+
+func doDecrementNumber(i *Input, _ termbox.Event) {
+	adjustQueryNumber(i, -1)
+}
+
+func adjustQueryNumber(i *Input, delta int64) {
+	if i.IsCommandMode() {
+		return
+	}
+
+	query, pos, ok := adjustNumber(i.query, i.caretPos, delta)
+	if !ok {
+		return
+	}
+
+	i.SetQuery(query)
+	i.caretPos = pos
+	i.ExecQuery()
+}
+
+// adjustNumber adds `delta` to the number under the caret at `pos`, or
+// if there's none, the first number after it. A "-" right before the
+// digits is treated as a sign, unless it follows a letter or a digit.
+// Leading zeros are kept, so that the number keeps its width. Returns
+// the new query and the caret position right after the number
+func adjustNumber(query []rune, pos int, delta int64) ([]rune, int, bool) {
+	if pos > len(query) {
+		pos = len(query)
+	}
+
+	start := pos
+	for start > 0 && isDigit(query[start-1]) {
+		start--
+	}
+	for start < len(query) && !isDigit(query[start]) {
+		start++
+	}
+	if start == len(query) {
+		return nil, 0, false
+	}
+	end := start
+	for end < len(query) && isDigit(query[end]) {
+		end++
+	}
+
+	digits := string(query[start:end])
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	if start > 0 && query[start-1] == '-' {
+		if start == 1 || !(unicode.IsLetter(query[start-2]) || unicode.IsDigit(query[start-2])) {
+			start--
+			n = -n
+		}
+	}
+	n += delta
+
+	var s string
+	if n < 0 {
+		s = "-"
+		n = -n
+	}
+	if len(digits) > 1 && digits[0] == '0' {
+		s += fmt.Sprintf("%0*d", len(digits), n)
+	} else {
+		s += strconv.FormatInt(n, 10)
+	}
+
+	adjusted := make([]rune, 0, len(query)+len(s))
+	adjusted = append(adjusted, query[:start]...)
+	adjusted = append(adjusted, []rune(s)...)
+	adjusted = append(adjusted, query[end:]...)
+	return adjusted, start + len(s), true
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+// extensionQuery returns a regular expression that matches the lines
+// ending with the same extension as `line`, or an empty string if
+// `line` has no extension
+func extensionQuery(line string) string {
+	ext := filepath.Ext(line)
+	if ext == "" || ext == "." {
+		return ""
+	}
+	return regexp.QuoteMeta(ext) + "$"
+}
+
 func doToggleSelection(i *Input, _ termbox.Event) {
-	if i.selection.Has(i.currentLine) {
-		i.selection.Remove(i.currentLine)
+	if i.IsLineSelected(i.currentLine) {
+		i.DeselectLine(i.currentLine)
 		return
 	}
-	i.selection.Add(i.currentLine)
+	i.SelectLine(i.currentLine)
 }
 
 func doToggleRangeMode(i *Input, _ termbox.Event) {
 	if i.IsRangeMode() {
 		for _, line := range i.SelectedRange() {
-			i.selection.Add(line)
+			i.SelectLine(line)
 		}
-		i.selection.Add(i.currentLine)
+		i.SelectLine(i.currentLine)
 
 		i.selectionRangeStart = NoSelectionRange
 	} else {
@@ -197,6 +734,29 @@ func doCancelRangeMode(i *Input, _ termbox.Event) {
 	i.DrawMatches(nil)
 }
 
+// doStartVisualSelect starts range mode, so that moving the cursor
+// selects all the lines between where it started and where it is
+func doStartVisualSelect(i *Input, _ termbox.Event) {
+	if i.IsRangeMode() {
+		return
+	}
+
+	if i.config.VisualSelect == VisualSelectReplace {
+		i.selection.Clear()
+	}
+	i.selectionRangeStart = i.currentLine
+	i.DrawMatches(nil)
+}
+
+// doEndVisualSelect adds the lines selected since peco.StartVisualSelect
+// to the selection
+func doEndVisualSelect(i *Input, ev termbox.Event) {
+	if !i.IsRangeMode() {
+		return
+	}
+	doToggleRangeMode(i, ev)
+}
+
 func doSelectNone(i *Input, _ termbox.Event) {
 	i.selection.Clear()
 	i.DrawMatches(nil)
@@ -204,48 +764,660 @@ func doSelectNone(i *Input, _ termbox.Event) {
 
 func doSelectAll(i *Input, _ termbox.Event) {
 	for lineno := 1; lineno <= len(i.current); lineno++ {
-		i.selection.Add(lineno)
+		i.SelectLine(lineno)
+	}
+	i.DrawMatches(nil)
+}
+
+func doSelectSimilar(i *Input, _ termbox.Event) {
+	n, err := i.SelectSimilar()
+	if err != nil {
+		i.SendStatusMsg(fmt.Sprintf("Invalid SimilarLines Pattern: %s", err))
+		return
 	}
+	i.SendStatusMsg(fmt.Sprintf("Selected %d more lines", n))
 	i.DrawMatches(nil)
 }
 
+func doCaptureKey(i *Input, _ termbox.Event) {
+	i.StartKeyCapture()
+	i.SendStatusMsg("Press a key...")
+}
+
 func doSelectVisible(i *Input, _ termbox.Event) {
 	pageStart := i.currentPage.offset
 	pageEnd := pageStart + i.currentPage.perPage
 	for lineno := pageStart; lineno <= pageEnd; lineno++ {
-		i.selection.Add(lineno)
+		i.SelectLine(lineno)
 	}
 	i.DrawMatches(nil)
 }
 
 func doFinish(i *Input, _ termbox.Event) {
-	// Must end with all the selected lines.
-	if i.selection.Len() == 0 {
-		i.selection.Add(i.currentLine)
+	if i.IsModalBuffer() {
+		i.AcceptModalBuffer(i)
+		return
 	}
 
-	i.result = []Match{}
-	for _, lineno := range append(i.selection, i.SelectedRange()...) {
-		if lineno <= len(i.current) {
-			i.result = append(i.result, i.current[lineno-1])
+	if i.IsCommandMode() {
+		x := &ExternalCommand{i.commandMode, string(i.query)}
+		i.EndCommandMode()
+		if x.Template != "" {
+			i.lastCommand = x
+			x.Run(i.Ctx, i.TargetLines())
 		}
+		i.DrawMatches(nil)
+		return
 	}
+
+	if i.IsInputEmpty() {
+		// There was nothing to choose from
+		i.ExitWith(1)
+		return
+	}
+
+	// Must end with all the selected lines.
+	i.result = i.TargetMatches()
 	i.ExitWith(0)
 }
 
-func doCancel(i *Input, ev termbox.Event) {
-	if i.keymap.Keyseq.InMiddleOfChain() {
-		i.keymap.Keyseq.CancelChain()
+// doAcceptInIndexOrder is like peco.Finish, but the lines are printed in
+// the order of the input, rather than the order they are displayed in
+func doAcceptInIndexOrder(i *Input, ev termbox.Event) {
+	if i.IsModalBuffer() || i.IsCommandMode() || i.IsInputEmpty() {
+		doFinish(i, ev)
 		return
 	}
 
-	if i.IsRangeMode() {
+	matches := i.TargetMatches()
+	sort.Sort(tieBreaker{matches, TieBreakIndex})
+	i.result = matches
+	i.ExitWith(0)
+}
+
+// doAcceptAndContinue outputs the selected lines (or the current line)
+// like peco.Finish, but clears the selection and keeps running, so
+// that more lines can be picked
+func doAcceptAndContinue(i *Input, ev termbox.Event) {
+	if i.IsModalBuffer() || i.IsCommandMode() || i.IsInputEmpty() {
+		doFinish(i, ev)
+		return
+	}
+
+	matches := i.TargetMatches()
+	if len(matches) == 0 {
+		return
+	}
+	if err := i.AcceptAndContinue(matches); err != nil {
+		i.SendStatusMsg(err.Error())
+		return
+	}
+
+	i.selection.Clear()
+	i.SendStatusMsg(fmt.Sprintf("Accepted %d lines", len(matches)))
+	i.DrawMatches(nil)
+}
+
+// cancelSteps are what peco.Cancel can do, keyed by the names used in
+// CancelSteps. Each of them returns false if it doesn't apply, so that
+// the next one is tried
+var cancelSteps = map[string]func(*Input, termbox.Event) bool{
+	CancelKeySequence: func(i *Input, _ termbox.Event) bool {
+		if !i.keymap.Keyseq.InMiddleOfChain() {
+			return false
+		}
+		i.keymap.Keyseq.CancelChain()
+		return true
+	},
+	CancelCommandMode: func(i *Input, _ termbox.Event) bool {
+		if !i.IsCommandMode() {
+			return false
+		}
+		i.EndCommandMode()
+		i.DrawMatches(nil)
+		return true
+	},
+	CancelRangeMode: func(i *Input, ev termbox.Event) bool {
+		if !i.IsRangeMode() {
+			return false
+		}
 		doCancelRangeMode(i, ev)
+		return true
+	},
+	CancelForEach: func(i *Input, _ termbox.Event) bool {
+		return i.CancelForEach()
+	},
+	CancelModalBuffer: func(i *Input, _ termbox.Event) bool {
+		if !i.IsModalBuffer() {
+			return false
+		}
+		i.PopBuffer()
+		i.DrawMatches(nil)
+		return true
+	},
+	CancelPreview: func(i *Input, _ termbox.Event) bool {
+		if !i.showPreview {
+			return false
+		}
+		i.showPreview = false
+		i.DrawMatches(nil)
+		return true
+	},
+	CancelQuery: func(i *Input, _ termbox.Event) bool {
+		if len(i.query) == 0 {
+			return false
+		}
+		i.SetQuery([]rune{})
+		if !i.ExecQuery() {
+			i.current = nil
+			i.DrawMatches(nil)
+		}
+		return true
+	},
+	CancelExit: func(i *Input, _ termbox.Event) bool {
+		// end program, exit with failure
+		i.ExitWith(1)
+		return true
+	},
+}
+
+func doCancel(i *Input, ev termbox.Event) {
+	for _, name := range i.config.CancelSteps {
+		if cancelSteps[name](i, ev) {
+			return
+		}
+	}
+}
+
+func doExecuteCommand(i *Input, _ termbox.Event) {
+	if i.IsCommandMode() {
 		return
 	}
+	i.StartCommandMode(ExecuteCommand)
+	i.DrawMatches(nil)
+}
 
-	// peco.Cancel -> end program, exit with failure
-	i.ExitWith(1)
+func doOpenInPane(i *Input, _ termbox.Event) {
+	lines := i.TargetLines()
+	if len(lines) == 0 {
+		return
+	}
+
+	name, err := i.OpenInPane(lines)
+	if err != nil {
+		i.SendStatusMsg(err.Error())
+		return
+	}
+	if len(lines) == 1 {
+		i.SendStatusMsg(fmt.Sprintf("Opened 1 line in %s", name))
+	} else {
+		i.SendStatusMsg(fmt.Sprintf("Opened %d lines in %s", len(lines), name))
+	}
+}
+
+func doForEachSelection(i *Input, _ termbox.Event) {
+	if i.config.ForEachCommand == "" {
+		i.SendStatusMsg("ForEachCommand is not configured")
+		return
+	}
+	if i.IsModalBuffer() {
+		return
+	}
+
+	lines := i.TargetLines()
+	if len(lines) == 0 {
+		return
+	}
+
+	if !i.StartForEach(lines) {
+		i.SendStatusMsg("peco.ForEachSelection is already running")
+	}
+}
+
+func doPipeSelection(i *Input, _ termbox.Event) {
+	if i.IsCommandMode() {
+		return
+	}
+	i.StartCommandMode(PipeCommand)
+	i.DrawMatches(nil)
+}
+
+func doRepeatLastCommand(i *Input, _ termbox.Event) {
+	if i.lastCommand == nil {
+		i.SendStatusMsg("No command to repeat")
+		return
+	}
+	i.lastCommand.Run(i.Ctx, i.TargetLines())
+}
+
+func doCopyToClipboard(i *Input, _ termbox.Event) {
+	cb, err := DetectClipboard()
+	if err != nil {
+		i.SendStatusMsg(err.Error())
+		return
+	}
+	copyLines(i, cb, i.TargetLines())
+}
+
+func doCopyToTmuxBuffer(i *Input, _ termbox.Event) {
+	if !TmuxClipboard.Available() {
+		i.SendStatusMsg("tmux is not available")
+		return
+	}
+	copyLines(i, TmuxClipboard, i.TargetLines())
+}
+
+func doCopyRelativePath(i *Input, _ termbox.Event) {
+	cb, err := DetectClipboard()
+	if err != nil {
+		i.SendStatusMsg(err.Error())
+		return
+	}
+
+	paths, err := i.relativePaths(i.TargetLines())
+	if err != nil {
+		i.SendStatusMsg(fmt.Sprintf("Failed to resolve paths: %s", err))
+		return
+	}
+	copyLines(i, cb, paths)
+}
+
+// doLoadNamedQuery replaces the query with the NamedQueries entry
+// `name`, and runs it
+func doLoadNamedQuery(i *Input, _ termbox.Event, name string) {
+	q, ok := i.config.NamedQueries[name]
+	if !ok {
+		i.SendStatusMsg(fmt.Sprintf("Unknown named query '%s'", name))
+		return
+	}
+
+	i.SetQuery([]rune(q))
+	if i.ExecQuery() {
+		return
+	}
+
+	i.current = nil
+	i.DrawMatches(nil)
+}
+
+// doExportAs formats the selected lines (or the current line) using
+// the export format named `name`, and copies the result to the
+// clipboard, or prints it and exits
+func doExportAs(i *Input, _ termbox.Event, name string) {
+	f, ok := i.config.ExportFormats[name]
+	if !ok {
+		i.SendStatusMsg(fmt.Sprintf("Unknown export format '%s'", name))
+		return
+	}
+
+	out := f.Format(i.TargetLines())
+	if f.Target == ExportToStdout {
+		i.exported = &out
+		i.ExitWith(0)
+		return
+	}
+
+	cb, err := DetectClipboard()
+	if err != nil {
+		i.SendStatusMsg(err.Error())
+		return
+	}
+	if err := cb.Copy(out); err != nil {
+		i.SendStatusMsg(fmt.Sprintf("Failed to copy to %s: %s", cb, err))
+		return
+	}
+	i.SendStatusMsg(fmt.Sprintf("Copied as %s to %s", name, cb))
+}
+
+func doBookmarkSelection(i *Input, _ termbox.Event, name string) {
+	i.BookmarkSelection(name)
+	i.SendStatusMsg(fmt.Sprintf("Bookmarked %d lines as %s", i.selection.Len(), name))
+}
+
+// combineBookmarkAction creates an action that replaces the selection
+// with the result of `op` applied to the selection and a bookmark
+func combineBookmarkAction(op func(Selection, Selection) Selection) func(*Input, termbox.Event, string) {
+	return func(i *Input, _ termbox.Event, name string) {
+		if !i.combineBookmark(name, op) {
+			i.SendStatusMsg(fmt.Sprintf("Unknown bookmark '%s'", name))
+			return
+		}
+		i.DrawMatches(nil)
+	}
+}
+
+func doCopyView(i *Input, _ termbox.Event) {
+	cb, err := DetectClipboard()
+	if err != nil {
+		i.SendStatusMsg(err.Error())
+		return
+	}
+
+	targets := i.currentTargets()
+	if i.config.CopyViewScope == CopyViewPage {
+		start := i.currentPage.offset
+		end := start + i.currentPage.perPage
+		if end > len(targets) {
+			end = len(targets)
+		}
+		if start > end {
+			start = end
+		}
+		targets = targets[start:end]
+	}
+
+	// Copy what is displayed, not what would be printed as the result
+	lines := make([]string, len(targets))
+	for n, m := range targets {
+		lines[n] = m.Line()
+	}
+	copyLines(i, cb, lines)
+}
+
+func doDrillDown(i *Input, _ termbox.Event) {
+	args := i.config.DrillDownCommand
+	if len(args) == 0 {
+		i.SendStatusMsg("DrillDownCommand is not configured")
+		return
+	}
+
+	m := i.CurrentMatch()
+	if m == nil {
+		return
+	}
+
+	// The command may take a while, so don't block the input. The
+	// buffer is pushed by the input loop once it's done
+	go func() {
+		lines, err := i.runBufferCommand(args, m.Output())
+		if err != nil {
+			i.SendStatusMsg(fmt.Sprintf("DrillDown failed: %s", err))
+			return
+		}
+		if len(lines) == 0 {
+			i.SendStatusMsg("Nothing to drill down into")
+			return
+		}
+
+		i.SendBuffer(func(i *Input) {
+			i.PushBuffer(m.Line(), lines)
+			i.DrawMatches(nil)
+		})
+	}()
+}
+
+func doLoadShellHistory(i *Input, _ termbox.Event) {
+	lines, err := i.loadShellHistory()
+	if err != nil {
+		i.SendStatusMsg(fmt.Sprintf("Failed to load shell history: %s", err))
+		return
+	}
+	if len(lines) == 0 {
+		i.SendStatusMsg("Shell history is empty")
+		return
+	}
+
+	i.PushBuffer("history", lines)
+	i.DrawMatches(nil)
+}
+
+func doRefineToSelection(i *Input, _ termbox.Event) {
+	if !i.RefineToSelection() {
+		i.SendStatusMsg("No lines are selected")
+		return
+	}
+	i.DrawMatches(nil)
+}
+
+func doDrillUp(i *Input, _ termbox.Event) {
+	if !i.PopBuffer() {
+		return
+	}
+
+	if i.ExecQuery() {
+		return
+	}
+	i.DrawMatches(nil)
+}
+
+func doNextSelection(i *Input, _ termbox.Event) {
+	if l, ok := i.selectedLineNumbers().Next(i.currentLine, i.config.WrapSelectionJump); ok {
+		i.currentLine = l
+		i.DrawMatches(nil)
+	}
+}
+
+func doPreviousSelection(i *Input, _ termbox.Event) {
+	if l, ok := i.selectedLineNumbers().Prev(i.currentLine, i.config.WrapSelectionJump); ok {
+		i.currentLine = l
+		i.DrawMatches(nil)
+	}
+}
+
+func doFlashMatches(i *Input, _ termbox.Event) {
+	d := time.Duration(i.config.FlashDuration) * time.Millisecond
+	i.flashUntil = time.Now().Add(d)
+	i.DrawMatches(nil)
+
+	// Redraw once the flash is over, without blocking the input
+	time.AfterFunc(d, func() {
+		i.DrawMatches(nil)
+	})
+}
+
+func doToggleViewportScope(i *Input, _ termbox.Event) {
+	if i.scopeCenter >= 0 {
+		i.scopeCenter = -1
+	} else {
+		m := i.CurrentMatch()
+		if m == nil {
+			return
+		}
+		i.scopeCenter = m.Index()
+	}
+
+	if !i.ExecQuery() {
+		i.current = nil
+		i.DrawMatches(nil)
+	}
+}
+
+func doToggleRelativeNumbers(i *Input, _ termbox.Event) {
+	i.relativeNumbers = !i.relativeNumbers
+	i.DrawMatches(nil)
+}
+
+// doLockResults toggles whether the lines are matched again when the
+// query changes. Unlocking applies the query as it is now
+func doLockResults(i *Input, _ termbox.Event) {
+	i.resultsLocked = !i.resultsLocked
+	if i.resultsLocked {
+		i.SendStatusMsg("Results locked")
+		i.DrawMatches(nil)
+		return
+	}
+
+	i.SendStatusMsg("Results unlocked")
+	if i.ExecQuery() {
+		return
+	}
+	i.current = nil
+	i.DrawMatches(nil)
+}
+
+func doToggleCompact(i *Input, _ termbox.Event) {
+	i.compact = !i.compact
+	i.DrawMatches(nil)
+}
+
+func doToggleInfoLine(i *Input, _ termbox.Event) {
+	i.showInfoLine = !i.showInfoLine
+	i.DrawMatches(nil)
+}
+
+func doToggleMatchDescription(i *Input, _ termbox.Event) {
+	i.matchDescription = !i.matchDescription
+	i.applyDescriptionSeparator()
+	if !i.ExecQuery() {
+		i.DrawMatches(nil)
+	}
+}
+
+func doToggleFullLineHighlight(i *Input, _ termbox.Event) {
+	i.config.HighlightFullLine = !i.config.HighlightFullLine
+	i.DrawMatches(nil)
+}
+
+func doTogglePreview(i *Input, _ termbox.Event) {
+	i.showPreview = !i.showPreview
+	if !i.showPreview {
+		i.previewFocused = false
+	}
+	i.DrawMatches(nil)
+}
+
+func doFocusPreview(i *Input, _ termbox.Event) {
+	if !i.showPreview {
+		i.SendStatusMsg("The preview pane is not displayed")
+		return
+	}
+	i.previewFocused = true
+	i.DrawMatches(nil)
+}
+
+func doFocusList(i *Input, _ termbox.Event) {
+	i.previewFocused = false
+	i.DrawMatches(nil)
+}
+
+func doToggleFocus(i *Input, ev termbox.Event) {
+	if i.previewFocused {
+		doFocusList(i, ev)
+	} else {
+		doFocusPreview(i, ev)
+	}
+}
+
+// doPreviewToMatch toggles whether the preview is scrolled to the line
+// that the current line refers to, e.g. "main.go:42: ..."
+func doPreviewToMatch(i *Input, _ termbox.Event) {
+	i.previewToMatch = !i.previewToMatch
+	if i.previewToMatch {
+		i.SendStatusMsg("Preview follows the line numbers")
+	} else {
+		i.SendStatusMsg("Preview starts from the top")
+	}
+	i.DrawMatches(nil)
+}
+
+func doTogglePreviewOutput(i *Input, _ termbox.Event) {
+	i.showOutputPreview = !i.showOutputPreview
+	i.DrawMatches(nil)
+}
+
+// doCommandPalette lets the user pick an action from the list of all
+// available actions, and executes it
+func doCommandPalette(i *Input, ev termbox.Event) {
+	if i.IsModalBuffer() {
+		return
+	}
+
+	// Actions that take an argument are listed as they are bound in
+	// the keymap, e.g. peco.ExportAs(git), as they can't be executed
+	// without one
+	seen := map[string]bool{}
+	names := []string{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range nameToActions {
+		add(name)
+	}
+	for name := range i.keymap.Action {
+		add(name)
+	}
+	for _, name := range i.keymap.boundParamActions() {
+		add(name)
+	}
+	sort.Strings(names)
+
+	lines := make([]Match, len(names))
+	for n, name := range names {
+		lines[n] = NewNoMatch(name, false, n)
+	}
+
+	i.PushModalBuffer("CommandPalette", lines, func(i *Input, m Match) {
+		a, err := i.keymap.resolveActionName(m.Line(), 0)
+		if err != nil {
+			i.SendStatusMsg(err.Error())
+			return
+		}
+		a.Execute(i, ev)
+	})
+	i.DrawMatches(nil)
+}
+
+// These are the arguments for peco.EnterPreview, which specify what is
+// done with the line picked from the preview
+const (
+	// EnterPreviewQuery appends the line to the query (default)
+	EnterPreviewQuery = "query"
+	// EnterPreviewSelect selects the lines that are the same as the line
+	EnterPreviewSelect = "select"
+)
+
+// doEnterPreview lists the lines of the preview of the current line,
+// so that one of them can be picked. See EnterPreviewQuery and
+// EnterPreviewSelect
+func doEnterPreview(i *Input, _ termbox.Event, mode string) {
+	if mode != EnterPreviewQuery && mode != EnterPreviewSelect {
+		i.SendStatusMsg(fmt.Sprintf("Unknown mode '%s'", mode))
+		return
+	}
+	if i.IsModalBuffer() {
+		return
+	}
+
+	m := i.CurrentMatch()
+	if m == nil {
+		return
+	}
+	preview, ok := i.Preview(m)
+	if !ok {
+		i.SendStatusMsg("No preview available")
+		return
+	}
+
+	lines := []Match{}
+	for _, l := range strings.Split(strings.TrimRight(preview, "\n"), "\n") {
+		lines = append(lines, NewNoMatch(l, false, len(lines)))
+	}
+
+	i.PushModalBuffer("Preview", lines, func(i *Input, m Match) {
+		if mode == EnterPreviewSelect {
+			n := 0
+			for lineno, target := range i.currentTargets() {
+				if target.Output() == m.Line() {
+					i.SelectLine(lineno + 1)
+					n++
+				}
+			}
+			i.SendStatusMsg(fmt.Sprintf("Selected %d lines", n))
+			i.DrawMatches(nil)
+			return
+		}
+
+		q := i.query
+		if len(q) > 0 && q[len(q)-1] != ' ' {
+			q = append(q, ' ')
+		}
+		i.SetQuery(append(q, []rune(m.Line())...))
+		i.ExecQuery()
+	})
+	i.DrawMatches(nil)
 }
 
 func doSelectPrevious(i *Input, ev termbox.Event) {
@@ -459,6 +1631,10 @@ func doKillEndOfLine(i *Input, _ termbox.Event) {
 
 func doDeleteAll(i *Input, _ termbox.Event) {
 	i.query = make([]rune, 0)
+	i.caretPos = 0
+	if i.ExecQuery() {
+		return
+	}
 	i.current = nil
 	i.DrawMatches(nil)
 }
@@ -481,8 +1657,52 @@ func doDeleteForwardChar(i *Input, _ termbox.Event) {
 	i.DrawMatches(nil)
 }
 
+func doTransposeChars(i *Input, _ termbox.Event) {
+	q, pos, ok := transposeChars(i.query, i.caretPos)
+	if !ok {
+		return
+	}
+	i.query = q
+	i.caretPos = pos
+
+	if i.ExecQuery() {
+		return
+	}
+
+	i.current = nil
+	i.DrawMatches(nil)
+}
+
+// transposeChars swaps the characters before and at `pos` in `q`, and
+// returns the new query and caret position, as C-t does in readline.
+// At the end of the query, the last two characters are swapped instead.
+// Returns false if there's nothing to swap
+func transposeChars(q []rune, pos int) ([]rune, int, bool) {
+	if len(q) < 2 || pos <= 0 {
+		return q, pos, false
+	}
+	if pos >= len(q) {
+		pos = len(q) - 1
+	}
+
+	buf := make([]rune, len(q))
+	copy(buf, q)
+	buf[pos-1], buf[pos] = buf[pos], buf[pos-1]
+	return buf, pos + 1, true
+}
+
 func doDeleteBackwardChar(i *Input, ev termbox.Event) {
 	if len(i.query) <= 0 {
+		if i.IsCommandMode() {
+			return
+		}
+
+		switch i.config.BackspaceOnEmptyQuery {
+		case BackspaceCancel:
+			doCancel(i, ev)
+		case BackspaceDrillUp:
+			doDrillUp(i, ev)
+		}
 		return
 	}
 
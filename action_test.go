@@ -1,6 +1,13 @@
 package peco
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
 
 func TestActionNames(t *testing.T) {
 	// These names MUST exist
@@ -33,4 +40,272 @@ func TestActionNames(t *testing.T) {
 			t.Errorf("Action %s should exist, but it does not", name)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestCancel(t *testing.T) {
+	newInput := func() (*Ctx, *Input) {
+		c := newTestCtx()
+		go func() {
+			for range c.DrawCh() {
+			}
+		}()
+		go func() {
+			for range c.QueryCh() {
+			}
+		}()
+		i := c.NewInput()
+		i.showPreview = true
+		i.SetQuery([]rune("foo"))
+		i.PushModalBuffer("palette", []Match{NewNoMatch("action", false, 0)}, func(*Input, Match) {})
+		return c, i
+	}
+	exited := func(c *Ctx) bool {
+		select {
+		case <-c.LoopCh():
+			return true
+		default:
+			return false
+		}
+	}
+
+	// By default, only the modal buffer is closed before exiting, even
+	// though a query is typed and the preview is open
+	c, i := newInput()
+	doCancel(i, termbox.Event{})
+	if i.IsModalBuffer() || exited(c) {
+		t.Fatalf("expected the modal buffer to be closed first")
+	}
+	doCancel(i, termbox.Event{})
+	if !exited(c) || c.ExitStatus != 1 {
+		t.Errorf("expected peco to exit with status 1 with a query typed, got %d", c.ExitStatus)
+	}
+
+	c, i = newInput()
+	c.config.CancelSteps = []string{CancelModalBuffer, CancelPreview, CancelQuery, CancelExit}
+	doCancel(i, termbox.Event{})
+	if i.IsModalBuffer() || !i.showPreview || exited(c) {
+		t.Fatalf("expected the modal buffer to be closed first")
+	}
+	doCancel(i, termbox.Event{})
+	if i.showPreview || string(i.query) != "foo" || exited(c) {
+		t.Fatalf("expected the preview to be closed next")
+	}
+	doCancel(i, termbox.Event{})
+	if len(i.query) != 0 || exited(c) {
+		t.Fatalf("expected the query to be cleared next")
+	}
+	doCancel(i, termbox.Event{})
+	if !exited(c) || c.ExitStatus != 1 {
+		t.Errorf("expected peco to exit with status 1, got %d", c.ExitStatus)
+	}
+}
+
+func TestCancelStepsMustExit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-cancel")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for txt, valid := range map[string]bool{
+		`{"CancelSteps": ["preview", "exit"]}`:  true,
+		`{"CancelSteps": ["preview", "query"]}`: false,
+		`{"CancelSteps": ["preview", "quit"]}`:  false,
+	} {
+		filename := filepath.Join(dir, "config.json")
+		if err := ioutil.WriteFile(filename, []byte(txt), 0644); err != nil {
+			t.Fatalf("Failed to write config: %s", err)
+		}
+		if err := NewConfig().ReadFilename(filename); (err == nil) != valid {
+			t.Errorf("expected %s to be valid = %t, got %v", txt, valid, err)
+		}
+	}
+}
+
+func TestExtensionQuery(t *testing.T) {
+	tests := map[string]string{
+		"main.go":           `\.go$`,
+		"src/peco.min.js":   `\.js$`,
+		"Makefile":          "",
+		"dir.d/":            "",
+		"archive.tar.gz":    `\.gz$`,
+		"path.with.dots/ab": "",
+	}
+	for line, expected := range tests {
+		if q := extensionQuery(line); q != expected {
+			t.Errorf("expected query for '%s' to be '%s', got '%s'", line, expected, q)
+		}
+	}
+}
+
+func TestTermAt(t *testing.T) {
+	query := []rune("foo bar  baz")
+	tests := map[int]string{
+		0:  "foo",
+		2:  "foo",
+		3:  "foo",
+		4:  "bar",
+		7:  "bar",
+		8:  "",
+		9:  "baz",
+		12: "baz",
+	}
+	for pos, expected := range tests {
+		if term := string(termAt(query, pos)); term != expected {
+			t.Errorf("expected term at %d to be '%s', got '%s'", pos, expected, term)
+		}
+	}
+}
+
+func TestEscapeQuery(t *testing.T) {
+	tests := []struct {
+		matcher  string
+		s        string
+		expected string
+	}{
+		{IgnoreCaseMatch, "a.b (c)", "a.b (c)"},
+		{RegexpMatch, "a.b (c)", `a\.b\x20\(c\)`},
+		{LikeMatch, `100%_a\b`, `100\%\_a\\b`},
+	}
+	for _, test := range tests {
+		if got := escapeQuery(test.matcher, test.s); got != test.expected {
+			t.Errorf("expected '%s' to be escaped as '%s' for %s, got '%s'", test.s, test.expected, test.matcher, got)
+		}
+	}
+
+	// The escaped text matches the line it came from, and only that
+	lines := []Match{NewNoMatch("a.b (c)", false, 0), NewNoMatch("axb (c)", false, 1)}
+	m := NewRegexpMatcher(false)
+	if results := m.Match(make(chan struct{}), escapeQuery(RegexpMatch, "a.b (c)"), lines); len(results) != 1 {
+		t.Errorf("expected 1 result, got %d", len(results))
+	}
+}
+
+func TestTransposeChars(t *testing.T) {
+	tests := []struct {
+		query    string
+		pos      int
+		expected string
+		caret    int
+	}{
+		{"abc", 1, "bac", 2},
+		{"abc", 3, "acb", 3},
+		{"日本語", 1, "本日語", 2},
+		{"aあ", 2, "あa", 2},
+		{"ｆｏｏ検索", 4, "ｆｏｏ索検", 5},
+	}
+	for _, test := range tests {
+		q, pos, ok := transposeChars([]rune(test.query), test.pos)
+		if !ok || string(q) != test.expected || pos != test.caret {
+			t.Errorf("expected transposing '%s' at %d to give '%s' at %d, got '%s' at %d", test.query, test.pos, test.expected, test.caret, string(q), pos)
+		}
+	}
+
+	for _, test := range []struct {
+		query string
+		pos   int
+	}{{"abc", 0}, {"あ", 1}, {"", 0}} {
+		if _, _, ok := transposeChars([]rune(test.query), test.pos); ok {
+			t.Errorf("expected nothing to transpose in '%s' at %d", test.query, test.pos)
+		}
+	}
+}
+
+func TestCompleteTerm(t *testing.T) {
+	lines := []string{"src/foo.go", "src/foo.c", "SRC/Foo.h"}
+	tests := map[string]string{
+		"":    "",
+		"sr":  "", // "SRC" differs in case
+		"foo": ".",
+		"bar": "",
+	}
+	for term, expected := range tests {
+		if completion := completeTerm(term, lines); completion != expected {
+			t.Errorf("expected completion of '%s' to be '%s', got '%s'", term, expected, completion)
+		}
+	}
+
+	if completion := completeTerm("", lines[:2]); completion != "src/foo." {
+		t.Errorf("expected the common prefix to be 'src/foo.', got '%s'", completion)
+	}
+	if completion := completeTerm("s", []string{"sあい", "sあう"}); completion != "あ" {
+		t.Errorf("expected multibyte characters to be kept intact, got '%s'", completion)
+	}
+}
+
+func TestAdjustNumber(t *testing.T) {
+	tests := []struct {
+		query    string
+		pos      int
+		delta    int64
+		expected string
+		caret    int
+	}{
+		{"issue 41", 8, 1, "issue 42", 8},
+		{"issue 41", 0, 1, "issue 42", 8}, // first number after the caret
+		{"v009 foo", 2, 1, "v010 foo", 4},
+		{"0", 1, -1, "-1", 2},
+		{"-5 foo", 0, 1, "-4 foo", 2},
+		{"-1", 1, 1, "0", 1},
+		{"a-5", 3, 1, "a-6", 3}, // "-" after a letter is not a sign
+		{"foo", 0, 1, "foo", 0},
+	}
+	for _, test := range tests {
+		query, caret, ok := adjustNumber([]rune(test.query), test.pos, test.delta)
+		if !ok {
+			if test.query != test.expected {
+				t.Errorf("expected '%s' to be adjusted", test.query)
+			}
+			continue
+		}
+		if string(query) != test.expected || caret != test.caret {
+			t.Errorf("expected '%s' to become '%s' (caret %d), got '%s' (caret %d)", test.query, test.expected, test.caret, string(query), caret)
+		}
+	}
+}
+
+func TestSiblingPrefixQuery(t *testing.T) {
+	tests := []struct {
+		line     string
+		delim    string
+		expected string
+	}{
+		{"a.b.c", ".", `^a\.b\.`},
+		{"a", ".", ""},
+		{"src/my dir/file", "/", `^src/my\x20dir/`},
+		{"a::b", "::", `^a::`},
+	}
+	for _, test := range tests {
+		if q := siblingPrefixQuery(test.line, test.delim); q != test.expected {
+			t.Errorf("expected query for '%s' to be '%s', got '%s'", test.line, test.expected, q)
+		}
+	}
+}
+
+func TestCommandPaletteParamActions(t *testing.T) {
+	c := newTestCtx()
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	i := c.NewInput()
+	i.keymap = NewKeymap(
+		map[string]string{"C-x,C-e": "export.Mine"},
+		map[string][]string{"my.Seed": {"peco.SeedQueryFromLine(palette)"}},
+		map[string]string{"export.Mine": "peco.ExportAs(palette)"},
+	)
+
+	doCommandPalette(i, termbox.Event{})
+	listed := map[string]bool{}
+	for _, m := range c.bufferLines() {
+		listed[m.Line()] = true
+	}
+	for _, name := range []string{"peco.ExportAs(palette)", "peco.SeedQueryFromLine(palette)", "my.Seed", "peco.Cancel"} {
+		if !listed[name] {
+			t.Errorf("expected '%s' to be listed", name)
+		}
+	}
+	if listed["peco.ExportAs"] {
+		t.Errorf("expected actions that need an argument to not be listed without one")
+	}
+}
@@ -0,0 +1,225 @@
+package peco
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// bufferFrame holds the state of a buffer that has been replaced via
+// PushBuffer, so that it can be restored by PopBuffer
+type bufferFrame struct {
+	label       string
+	lines       []Match
+	current     []Match
+	query       []rune
+	caretPos    int
+	currentLine int
+	selection   Selection
+	pinned      map[int]bool
+	// accept is called instead of peco.Finish for the buffer that
+	// replaced this frame. When nil, peco.Finish works as usual
+	accept func(*Input, Match)
+}
+
+// currentTargets returns the lines that are currently displayed
+func (c *Ctx) currentTargets() []Match {
+	if c.current != nil {
+		return c.current
+	}
+	return c.bufferLines()
+}
+
+// bufferLines returns the lines in the current buffer. The reader may
+// be appending to them, so c.lines must not be read directly
+func (c *Ctx) bufferLines() []Match {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return c.lines
+}
+
+// CurrentMatch returns the line that the cursor is currently on, or
+// nil if there's no such line
+func (c *Ctx) CurrentMatch() Match {
+	targets := c.currentTargets()
+	if c.currentLine < 1 || c.currentLine > len(targets) {
+		return nil
+	}
+	return targets[c.currentLine-1]
+}
+
+// rootBuffer returns the buffer that input is being read into. This
+// is the original buffer, even if it has been replaced via PushBuffer.
+// bufferMutex must be held while it's used
+func (c *Ctx) rootBuffer() *[]Match {
+	if len(c.frames) > 0 {
+		return &c.frames[0].lines
+	}
+	return &c.lines
+}
+
+// rootBufferLen returns the number of lines read into the root buffer
+func (c *Ctx) rootBufferLen() int {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return len(*c.rootBuffer())
+}
+
+// appendInput appends `m`, which was read from the input, to the root
+// buffer. If the buffer is overflowing, the oldest line is removed
+func (c *Ctx) appendInput(m Match) {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+
+	lines := c.rootBuffer()
+	*lines = append(*lines, m)
+	if c.isBufferOverflowing() {
+		*lines = (*lines)[1:]
+	}
+}
+
+// PushBuffer replaces the buffer with `lines`, remembering the previous
+// buffer along with its query. `label` is used to display where we are
+func (c *Ctx) PushBuffer(label string, lines []Match) {
+	c.PushModalBuffer(label, lines, nil)
+}
+
+// PushModalBuffer is like PushBuffer, but peco.Finish calls `accept`
+// with the current line after restoring the previous buffer, instead
+// of exiting peco
+func (c *Ctx) PushModalBuffer(label string, lines []Match, accept func(*Input, Match)) {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+
+	c.frames = append(c.frames, bufferFrame{
+		label,
+		c.lines,
+		c.current,
+		c.query,
+		c.caretPos,
+		c.currentLine,
+		c.selection,
+		c.pinned,
+		accept,
+	})
+	c.selection = Selection{}
+	c.pinned = nil
+	c.lines = lines
+	c.current = nil
+	c.query = []rune{}
+	c.caretPos = 0
+	c.currentLine = 1
+}
+
+// RefineToSelection replaces the buffer with the selected lines, in the
+// order that they appear in the buffer. The lines keep their positions
+// in the input, and the selection is restored by PopBuffer. Returns
+// false if no lines are selected
+func (c *Ctx) RefineToSelection() bool {
+	lines := []Match{}
+	for _, m := range c.bufferLines() {
+		if c.selection.Has(m.Index()) {
+			lines = append(lines, NewNoMatch(m.Buffer(), c.enableSep, m.Index()))
+		}
+	}
+	if len(lines) == 0 {
+		return false
+	}
+
+	c.PushBuffer("selection", lines)
+	return true
+}
+
+// PopBuffer restores the buffer that was replaced by the last call to
+// PushBuffer. Returns false if there was nothing to restore
+func (c *Ctx) PopBuffer() bool {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+
+	if len(c.frames) == 0 {
+		return false
+	}
+
+	f := c.frames[len(c.frames)-1]
+	c.frames = c.frames[:len(c.frames)-1]
+	c.lines = f.lines
+	c.current = f.current
+	c.query = f.query
+	c.caretPos = f.caretPos
+	c.currentLine = f.currentLine
+	c.selection = f.selection
+	c.pinned = f.pinned
+	return true
+}
+
+// IsModalBuffer returns true if the current buffer was pushed via
+// PushModalBuffer
+func (c *Ctx) IsModalBuffer() bool {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return len(c.frames) > 0 && c.frames[len(c.frames)-1].accept != nil
+}
+
+// IsRootBuffer returns true if the buffer hasn't been replaced via
+// PushBuffer
+func (c *Ctx) IsRootBuffer() bool {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return len(c.frames) == 0
+}
+
+// AcceptModalBuffer restores the previous buffer, and calls the
+// accept callback with the line that the cursor was on
+func (c *Ctx) AcceptModalBuffer(i *Input) {
+	c.bufferMutex.Lock()
+	accept := c.frames[len(c.frames)-1].accept
+	c.bufferMutex.Unlock()
+	m := c.CurrentMatch()
+	c.PopBuffer()
+	i.DrawMatches(nil)
+	if m != nil {
+		accept(i, m)
+	}
+}
+
+// Breadcrumb returns the labels of all the buffers that have been pushed
+func (c *Ctx) Breadcrumb() string {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+
+	labels := make([]string, len(c.frames))
+	for i, f := range c.frames {
+		labels[i] = f.label
+	}
+	return strings.Join(labels, " > ")
+}
+
+// expandLineArgs replaces the special token "$LINE" in `args` by `line`
+func expandLineArgs(args []string, line string) []string {
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "$LINE" {
+			arg = line
+		}
+		expanded[i] = arg
+	}
+	return expanded
+}
+
+// runBufferCommand runs the command specified by `args`, and returns
+// its output as a buffer. The special token "$LINE" in args is
+// replaced by `line`
+func (c *Ctx) runBufferCommand(args []string, line string) ([]Match, error) {
+	cmdArgs := expandLineArgs(args, line)
+	out, err := exec.Command(cmdArgs[0], cmdArgs[1:]...).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []Match{}
+	for _, l := range strings.Split(string(out), "\n") {
+		if l != "" {
+			lines = append(lines, NewNoMatch(l, c.enableSep, len(lines)))
+		}
+	}
+	return lines, nil
+}
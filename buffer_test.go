@@ -0,0 +1,104 @@
+package peco
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+func bufferText(lines []Match) string {
+	text := make([]string, len(lines))
+	for n, m := range lines {
+		text[n] = m.Line()
+	}
+	return strings.Join(text, ",")
+}
+
+func TestPushBufferWhileReading(t *testing.T) {
+	c := newTestCtx()
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	go func() {
+		for range c.QueryCh() {
+		}
+	}()
+
+	in, out := io.Pipe()
+	r := c.NewBufferReader(in)
+	c.AddWaitGroup(1)
+	go r.Loop()
+
+	waitForLines := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for c.rootBufferLen() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d lines to be read, got %d", n, c.rootBufferLen())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	io.WriteString(out, "foo\n")
+	<-r.InputReadyCh()
+	waitForLines(1)
+
+	c.PushBuffer("drill", []Match{NewNoMatch("child", false, 0)})
+	c.PushModalBuffer("palette", []Match{NewNoMatch("action", false, 0)}, func(*Input, Match) {})
+	io.WriteString(out, "bar\nbaz\n")
+	waitForLines(3)
+
+	if s := bufferText(c.bufferLines()); s != "action" {
+		t.Errorf("expected the input to not be read into the pushed buffer, got '%s'", s)
+	}
+	c.PopBuffer()
+	if s := bufferText(c.bufferLines()); s != "child" {
+		t.Errorf("expected the input to not be read into the pushed buffer, got '%s'", s)
+	}
+	c.PopBuffer()
+	if s := bufferText(c.bufferLines()); s != "foo,bar,baz" {
+		t.Errorf("expected the root buffer to have all of the input, got '%s'", s)
+	}
+
+	// the root buffer is read into once more after popping
+	io.WriteString(out, "qux\n")
+	waitForLines(4)
+	if s := bufferText(c.bufferLines()); s != "foo,bar,baz,qux" {
+		t.Errorf("expected the root buffer to have all of the input, got '%s'", s)
+	}
+
+	out.Close()
+	c.WaitDone()
+}
+
+func TestDrillDown(t *testing.T) {
+	c := newTestCtx()
+	c.config.DrillDownCommand = []string{"echo", "child of", "$LINE"}
+	c.lines = []Match{NewNoMatch("foo", false, 0)}
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	i := c.NewInput()
+
+	doDrillDown(i, termbox.Event{})
+
+	// the buffer is only pushed once the input loop runs the request
+	var r HubReq
+	select {
+	case r = <-c.BufferCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the buffer to be sent to the input loop")
+	}
+	if !c.IsRootBuffer() {
+		t.Errorf("expected the buffer to not be pushed outside of the input loop")
+	}
+	r.DataInterface().(func(*Input))(i)
+	if s := bufferText(c.bufferLines()); s != "child of foo" {
+		t.Errorf("expected the output of the command to be pushed, got '%s'", s)
+	}
+}
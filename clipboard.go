@@ -0,0 +1,96 @@
+package peco
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Clipboard is something that text can be copied to
+type Clipboard interface {
+	Copy(string) error
+	String() string
+}
+
+// CommandClipboard copies text by feeding it to an external command's stdin
+type CommandClipboard struct {
+	name string
+	args []string
+}
+
+// TmuxClipboard is the backend that loads text into a tmux paste buffer
+var TmuxClipboard = CommandClipboard{"tmux buffer", []string{"tmux", "load-buffer", "-"}}
+
+// clipboardCandidates lists the backends that are tried in order, when
+// peco is not running inside tmux
+var clipboardCandidates = []struct {
+	env       string // if non-empty, this variable must be set
+	clipboard CommandClipboard
+}{
+	{"WAYLAND_DISPLAY", CommandClipboard{"clipboard", []string{"wl-copy"}}},
+	{"DISPLAY", CommandClipboard{"clipboard", []string{"xclip", "-selection", "clipboard"}}},
+	{"DISPLAY", CommandClipboard{"clipboard", []string{"xsel", "--clipboard", "--input"}}},
+}
+
+// Copy runs the command with `s` as its input
+func (c CommandClipboard) Copy(s string) error {
+	cmd := exec.Command(c.args[0], c.args[1:]...)
+	cmd.Stdin = strings.NewReader(s)
+	return cmd.Run()
+}
+
+func (c CommandClipboard) String() string {
+	return c.name
+}
+
+// Available returns true if the command exists in $PATH
+func (c CommandClipboard) Available() bool {
+	_, err := exec.LookPath(c.args[0])
+	return err == nil
+}
+
+// DetectClipboard figures out which clipboard to use. When running
+// inside tmux, the tmux paste buffer is used.
+func DetectClipboard() (Clipboard, error) {
+	if os.Getenv("TMUX") != "" && TmuxClipboard.Available() {
+		return TmuxClipboard, nil
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return CommandClipboard{"clipboard", []string{"pbcopy"}}, nil
+	case "windows":
+		return CommandClipboard{"clipboard", []string{"clip"}}, nil
+	}
+
+	for _, c := range clipboardCandidates {
+		if c.env != "" && os.Getenv(c.env) == "" {
+			continue
+		}
+		if c.clipboard.Available() {
+			return c.clipboard, nil
+		}
+	}
+	return nil, fmt.Errorf("error: No clipboard available")
+}
+
+// copyLines copies `lines` to `cb`, and reports the result in the
+// status message
+func copyLines(i *Input, cb Clipboard, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	if err := cb.Copy(strings.Join(lines, "\n")); err != nil {
+		i.SendStatusMsg(fmt.Sprintf("Failed to copy to %s: %s", cb, err))
+		return
+	}
+
+	if len(lines) == 1 {
+		i.SendStatusMsg(fmt.Sprintf("Copied 1 line to %s", cb))
+	} else {
+		i.SendStatusMsg(fmt.Sprintf("Copied %d lines to %s", len(lines), cb))
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/jessevdk/go-flags"
@@ -15,6 +17,7 @@ var version = "v0.2.1"
 func showHelp() {
 	const v = ` 
 Usage: peco [options] [FILE]
+       peco [options] --exec=COMMAND
 
 Options:
   -h, --help            show this help message and exit
@@ -26,6 +29,18 @@ Options:
   --null                expect NUL (\0) as separator for target/output (EXPERIMENTAL)
   --initial-index       position of the initial index of the selection (0 base)
   --prompt              specify prompt
+  --output-prefix       string to prepend to each output line
+  --output-suffix       string to append to each output line
+  --output-escape       how to escape the wrapped lines (suffix/none/double-quote/single-quote)
+  --output-separator    string to join output lines with, instead of newlines
+  --input-json          read the input as a JSON array of {"text": ..., "preview": ...}
+  --input-encoding      encoding of the input, e.g. Shift_JIS (default: UTF-8)
+  --exec                run the command using the shell, and read its output instead of FILE or stdin
+  --on-empty-input      what to do when the input has no lines (exit/message/stderr)
+  --accept-mode         what to do with the result (print/copy, default: print)
+  --log                 write events to the file, for debugging
+  --log-level           how much to write to the log file (error/info/debug, default: info)
+  --state-key           identifies the source, so that the query and position can be restored (default: FILE)
 `
 	os.Stderr.Write([]byte(v))
 }
@@ -41,6 +56,18 @@ type cmdOptions struct {
 	OptEnableNullSep bool   `long:"null" description:"expect NUL (\\0) as separator for target/output"`
 	OptInitialIndex  int    `long:"initial-index" description:"position of the initial index of the selection (0 base)"`
 	OptPrompt        string `long:"prompt"`
+	OptOutputPrefix  string `long:"output-prefix" description:"string to prepend to each output line"`
+	OptOutputSuffix  string `long:"output-suffix" description:"string to append to each output line"`
+	OptOutputEscape  string `long:"output-escape" description:"how to escape the wrapped lines (suffix/none/double-quote/single-quote)"`
+	OptOutputSep     string `long:"output-separator" description:"string to join output lines with, instead of newlines"`
+	OptInputJSON     bool   `long:"input-json" description:"read the input as a JSON array of {\"text\": ..., \"preview\": ...}"`
+	OptInputEncoding string `long:"input-encoding" description:"encoding of the input, e.g. Shift_JIS"`
+	OptExec          string `long:"exec" description:"run the command using the shell, and read its output instead of FILE or stdin"`
+	OptOnEmptyInput  string `long:"on-empty-input" description:"what to do when the input has no lines (exit/message/stderr)"`
+	OptAcceptMode    string `long:"accept-mode" description:"what to do with the result (print/copy)"`
+	OptLog           string `long:"log" description:"write events to the file, for debugging"`
+	OptLogLevel      string `long:"log-level" description:"how much to write to the log file (error/info/debug)" default:"info"`
+	OptStateKey      string `long:"state-key" description:"identifies the source, so that the query and position can be restored"`
 }
 
 // BufferSize returns the specified buffer size. Fulfills peco.CtxOptions
@@ -89,17 +116,20 @@ func main() {
 		return
 	}
 
-	var in *os.File
+	var in io.ReadCloser
 
-	// receive in from either a file or Stdin
+	// receive in from either a file or Stdin, unless a command was
+	// given, which is started once the settings have been read
 	switch {
+	case opts.OptExec != "":
 	case len(args) > 0:
-		in, err = os.Open(args[0])
+		f, err := os.Open(args[0])
 		if err != nil {
 			st = 1
 			fmt.Fprintln(os.Stderr, err)
 			return
 		}
+		in = f
 	case !peco.IsTty(os.Stdin.Fd()):
 		in = os.Stdin
 	default:
@@ -109,19 +139,23 @@ func main() {
 	}
 
 	ctx := peco.NewCtx(opts)
+	if opts.OptLog != "" {
+		if err := ctx.SetLogFile(opts.OptLog, opts.OptLogLevel); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			st = 1
+			return
+		}
+	}
 	defer func() {
 		if err := recover(); err != nil {
 			st = 1
 			fmt.Fprintf(os.Stderr, "Error:\n%s", err)
 		}
 
-		if result := ctx.Result(); result != nil {
-			for _, match := range result {
-				line := match.Output()
-				if line[len(line)-1] != '\n' {
-					line = line + "\n"
-				}
-				fmt.Fprint(os.Stdout, line)
+		if out, ok := ctx.FinalOutput(); ok {
+			if err := ctx.WriteOutput(os.Stdout, out); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				st = 1
 			}
 		}
 	}()
@@ -149,6 +183,84 @@ func main() {
 		ctx.SetCurrentMatcher(peco.CaseSensitiveMatch)
 	}
 
+	if opts.OptEnableNullSep && (len(opts.OptOutputPrefix) > 0 || len(opts.OptOutputSuffix) > 0) {
+		fmt.Fprintln(os.Stderr, "error: --output-prefix and --output-suffix can't be used with --null")
+		st = 1
+		return
+	}
+
+	if len(opts.OptOutputPrefix) > 0 {
+		ctx.SetOutputPrefix(opts.OptOutputPrefix)
+	}
+
+	if len(opts.OptOutputSuffix) > 0 {
+		ctx.SetOutputSuffix(opts.OptOutputSuffix)
+	}
+
+	if len(opts.OptOutputEscape) > 0 {
+		if err := ctx.SetOutputEscape(opts.OptOutputEscape); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			st = 1
+			return
+		}
+	}
+
+	if len(opts.OptOutputSep) > 0 {
+		ctx.SetOutputSeparator(opts.OptOutputSep)
+	}
+
+	if opts.OptInputJSON {
+		ctx.SetInputJSON(true)
+	}
+
+	if len(opts.OptInputEncoding) > 0 {
+		if err := ctx.SetInputEncoding(opts.OptInputEncoding); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			st = 1
+			return
+		}
+	}
+
+	if len(opts.OptAcceptMode) > 0 {
+		if err := ctx.SetAcceptMode(opts.OptAcceptMode); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			st = 1
+			return
+		}
+	}
+
+	if len(opts.OptOnEmptyInput) > 0 {
+		if err := ctx.SetOnEmptyInput(opts.OptOnEmptyInput); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			st = 1
+			return
+		}
+	}
+
+	stateKey := opts.OptStateKey
+	if stateKey == "" && len(args) > 0 {
+		if abs, err := filepath.Abs(args[0]); err == nil {
+			stateKey = abs
+		}
+	}
+	ctx.SetStateKey(stateKey)
+
+	// The lines accepted by peco.AcceptAndContinue can be written right
+	// away, unless stdout is the terminal that peco is drawn on, in
+	// which case they have to wait until peco exits
+	if !peco.IsTty(os.Stdout.Fd()) {
+		ctx.SetContinueOutput(os.Stdout)
+	}
+
+	if opts.OptExec != "" {
+		in, err = ctx.StartSourceCommand(opts.OptExec)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			st = 1
+			return
+		}
+	}
+
 	// Try waiting for something available in the source stream
 	// before doing any terminal initialization (also done by termbox)
 	reader := ctx.NewBufferReader(in)
@@ -158,6 +270,15 @@ func main() {
 	// This channel blocks until we receive something from `in`
 	<-reader.InputReadyCh()
 
+	// The reader may have already given up (e.g. there was no input),
+	// in which case there's no point in initializing the terminal
+	select {
+	case <-ctx.LoopCh():
+		st = ctx.ExitStatus
+		return
+	default:
+	}
+
 	err = peco.TtyReady()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -200,6 +321,8 @@ func main() {
 	if len(opts.OptQuery) > 0 {
 		ctx.SetQuery([]rune(opts.OptQuery))
 		ctx.ExecQuery()
+	} else if ctx.RestoreState() {
+		ctx.ExecQuery()
 	} else {
 		view.Refresh()
 	}
@@ -210,5 +333,9 @@ func main() {
 
 	ctx.WaitDone()
 
+	if err := ctx.SaveState(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
 	st = ctx.ExitStatus
 }
@@ -0,0 +1,157 @@
+package peco
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CommandKind describes how an external command receives its target lines
+type CommandKind int
+
+const (
+	// NoCommand means that we are not in the middle of entering a command
+	NoCommand CommandKind = iota
+	// ExecuteCommand runs the command once per target line, substituting
+	// "{}" in the command template with the line
+	ExecuteCommand
+	// PipeCommand runs the command once, feeding all of the target
+	// lines through its stdin
+	PipeCommand
+)
+
+// Prompt returns the prompt that is displayed while the user is
+// entering a command of this kind
+func (k CommandKind) Prompt() string {
+	switch k {
+	case ExecuteCommand:
+		return "COMMAND>"
+	case PipeCommand:
+		return "PIPE>"
+	default:
+		return ""
+	}
+}
+
+// ExternalCommand is a command template that the user entered via
+// peco.ExecuteCommand or peco.PipeSelection
+type ExternalCommand struct {
+	Kind     CommandKind
+	Template string
+}
+
+// expandCommandTemplate replaces all occurrences of "{}" in the template
+// with the shell-quoted line. If the template does not contain a "{}",
+// the line is appended to the end of the command.
+func expandCommandTemplate(tmpl string, line string) string {
+	quoted := shellQuote(line)
+	if !strings.Contains(tmpl, "{}") {
+		return tmpl + " " + quoted
+	}
+	return strings.Replace(tmpl, "{}", quoted, -1)
+}
+
+// Run executes the command against `lines`. The result is reported in
+// the status message once the command(s) finish
+func (x ExternalCommand) Run(c *Ctx, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+
+	go func() {
+		var err error
+		var out []byte
+
+		switch x.Kind {
+		case PipeCommand:
+			cmd := shellCommand(x.Template)
+			cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+			out, err = cmd.CombinedOutput()
+		default:
+			for _, line := range lines {
+				var o []byte
+				o, err = shellCommand(expandCommandTemplate(x.Template, line)).CombinedOutput()
+				out = append(out, o...)
+				if err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			c.SendStatusMsg(fmt.Sprintf("Command failed: %s", err))
+			return
+		}
+
+		msg := strings.TrimSpace(string(out))
+		if i := strings.IndexByte(msg, '\n'); i > -1 {
+			msg = msg[:i]
+		}
+		if msg == "" {
+			msg = fmt.Sprintf("Executed '%s'", x.Template)
+		}
+		c.SendStatusMsg(msg)
+	}()
+}
+
+// IsCommandMode returns true if the user is currently entering
+// a command template instead of a query
+func (c *Ctx) IsCommandMode() bool {
+	return c.commandMode != NoCommand
+}
+
+// StartCommandMode saves the current query and switches the query
+// line to accept a command template of kind `k`
+func (c *Ctx) StartCommandMode(k CommandKind) {
+	c.savedQuery = c.query
+	c.commandMode = k
+	c.query = []rune{}
+	c.caretPos = 0
+}
+
+// EndCommandMode restores the query that was saved by StartCommandMode
+func (c *Ctx) EndCommandMode() {
+	c.commandMode = NoCommand
+	c.SetQuery(c.savedQuery)
+	c.savedQuery = nil
+}
+
+// TargetLines returns the output strings of the lines that an action
+// should operate on: the selected lines, if any, or the current line.
+func (c *Ctx) TargetLines() []string {
+	matches := c.TargetMatches()
+	lines := make([]string, len(matches))
+	for n, m := range matches {
+		lines[n] = m.Output()
+	}
+	return lines
+}
+
+// TargetMatches is like TargetLines, but returns the lines themselves.
+// The selected lines are returned in the order they are displayed,
+// followed by those that are selected but not displayed (i.e. they
+// don't match the current query)
+func (c *Ctx) TargetMatches() []Match {
+	inRange := c.SelectedRange()
+	matches := []Match{}
+	found := map[int]bool{}
+	for n, m := range c.currentTargets() {
+		if c.selection.Has(m.Index()) || inRange.Has(n+1) {
+			matches = append(matches, m)
+			found[m.Index()] = true
+		}
+	}
+
+	for _, m := range c.bufferLines() {
+		if c.selection.Has(m.Index()) && !found[m.Index()] {
+			matches = append(matches, m)
+			found[m.Index()] = true
+		}
+	}
+
+	if len(matches) == 0 {
+		if m := c.CurrentMatch(); m != nil {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,56 @@
+package peco
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestExpandCommandTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl     string
+		line     string
+		expected string
+	}{
+		{"less {}", "foo", "less " + shellQuote("foo")},
+		{"less", "foo", "less " + shellQuote("foo")},
+		{"cp {} {}.bak", "a b", "cp " + shellQuote("a b") + " " + shellQuote("a b") + ".bak"},
+		{"echo {}", `it's "quoted"`, "echo " + shellQuote(`it's "quoted"`)},
+		{"echo {}", "", "echo " + shellQuote("")},
+	}
+	for _, test := range tests {
+		if s := expandCommandTemplate(test.tmpl, test.line); s != test.expected {
+			t.Errorf("expected '%s' with %q to be %q, got %q", test.tmpl, test.line, test.expected, s)
+		}
+	}
+}
+
+func TestRepeatLastCommand(t *testing.T) {
+	c := newTestCtx()
+	c.lines = []Match{NewNoMatch("foo", false, 0)}
+	i := c.NewInput()
+
+	status := func() string {
+		select {
+		case r := <-c.StatusMsgCh():
+			return r.DataString()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a status message")
+		}
+		return ""
+	}
+
+	doRepeatLastCommand(i, termbox.Event{})
+	if msg := status(); msg != "No command to repeat" {
+		t.Errorf("expected to be told there's no command, got '%s'", msg)
+	}
+
+	i.lastCommand = &ExternalCommand{ExecuteCommand, "echo {}"}
+	for n := 0; n < 2; n++ {
+		doRepeatLastCommand(i, termbox.Event{})
+		if msg := status(); msg != "foo" {
+			t.Errorf("expected the last command to be run for the current line, got '%s'", msg)
+		}
+	}
+}
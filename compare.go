@@ -0,0 +1,108 @@
+package peco
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// columnComparison is a term in the query that compares a column of
+// each line with a number, e.g. "size>1000" (see NumericColumns)
+type columnComparison struct {
+	column int // starting from 1
+	op     string
+	value  float64
+}
+
+// comparisonRegexp matches "name OP value". The longer operators come
+// first, so that ">=" isn't taken as ">" followed by "=value"
+var comparisonRegexp = regexp.MustCompile(`^(\w+)(>=|<=|!=|>|<|=)(.+)$`)
+
+// splitComparisons removes the comparisons from `query`, and returns
+// the rest of the query along with them. A term is only taken as a
+// comparison if it refers to one of NumericColumns, and its value is
+// a number. Otherwise it's matched as text, as usual
+func (c *Ctx) splitComparisons(query string) (string, []columnComparison) {
+	if len(c.config.NumericColumns) == 0 {
+		return query, nil
+	}
+
+	terms := []string{}
+	comparisons := []columnComparison{}
+	for _, term := range strings.Fields(query) {
+		if x, ok := c.parseComparison(term); ok {
+			comparisons = append(comparisons, x)
+		} else {
+			terms = append(terms, term)
+		}
+	}
+	if len(comparisons) == 0 {
+		return query, nil
+	}
+	return strings.Join(terms, " "), comparisons
+}
+
+// parseComparison parses `term` as a comparison. Returns false if it
+// isn't one
+func (c *Ctx) parseComparison(term string) (columnComparison, bool) {
+	m := comparisonRegexp.FindStringSubmatch(term)
+	if m == nil {
+		return columnComparison{}, false
+	}
+
+	column, ok := c.config.NumericColumns[m[1]]
+	if !ok {
+		return columnComparison{}, false
+	}
+	value, err := strconv.ParseFloat(m[3], 64)
+	if err != nil {
+		return columnComparison{}, false
+	}
+	return columnComparison{column, m[2], value}, true
+}
+
+// Match returns true if the column of `line` satisfies the comparison.
+// Lines where the column isn't a number never do
+func (x columnComparison) Match(line, delim string) bool {
+	cols := splitColumns(line, delim)
+	if x.column > len(cols) {
+		return false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(cols[x.column-1]), 64)
+	if err != nil {
+		return false
+	}
+
+	switch x.op {
+	case ">":
+		return v > x.value
+	case "<":
+		return v < x.value
+	case ">=":
+		return v >= x.value
+	case "<=":
+		return v <= x.value
+	case "=":
+		return v == x.value
+	case "!=":
+		return v != x.value
+	}
+	return false
+}
+
+// filterComparisons returns the lines in `buf` that satisfy all of
+// `comparisons`
+func (c *Ctx) filterComparisons(buf []Match, comparisons []columnComparison) []Match {
+	delim := c.columnDelimiter()
+	results := []Match{}
+LINES:
+	for _, m := range buf {
+		for _, x := range comparisons {
+			if !x.Match(m.Line(), delim) {
+				continue LINES
+			}
+		}
+		results = append(results, m)
+	}
+	return results
+}
@@ -0,0 +1,57 @@
+package peco
+
+import "testing"
+
+func TestSplitComparisons(t *testing.T) {
+	c := newTestCtx()
+	c.config.NumericColumns = map[string]int{"size": 2}
+
+	text, comparisons := c.splitComparisons("foo size>=1000 bar>5 size=abc")
+	if text != "foo bar>5 size=abc" {
+		t.Errorf("expected only the valid comparisons to be removed, got '%s'", text)
+	}
+	if len(comparisons) != 1 || comparisons[0] != (columnComparison{2, ">=", 1000}) {
+		t.Errorf("unexpected comparisons %#v", comparisons)
+	}
+
+	c.config.NumericColumns = nil
+	if text, comparisons := c.splitComparisons("size>1000"); text != "size>1000" || comparisons != nil {
+		t.Errorf("expected no comparisons without NumericColumns, got '%s' and %#v", text, comparisons)
+	}
+}
+
+func TestFilterComparisons(t *testing.T) {
+	c := newTestCtx()
+	c.config.NumericColumns = map[string]int{"size": 2, "mtime": 3}
+	buf := []Match{
+		NewNoMatch("a.txt 512 10", false, 0),
+		NewNoMatch("b.txt 2048 20", false, 1),
+		NewNoMatch("c.txt - 30", false, 2),
+		NewNoMatch("d.txt 1000", false, 3),
+		NewNoMatch("e.txt 4096.5 40", false, 4),
+	}
+
+	tests := map[string][]int{
+		"size>1000":          {1, 4},
+		"size>=1000":         {1, 3, 4},
+		"size<1000":          {0},
+		"size<=1000":         {0, 3},
+		"size=1000":          {3},
+		"size!=1000":         {0, 1, 4},
+		"size>1000 mtime<30": {1},
+		"mtime>=30":          {2, 4},
+	}
+	for query, expected := range tests {
+		_, comparisons := c.splitComparisons(query)
+		results := c.filterComparisons(buf, comparisons)
+		if len(results) != len(expected) {
+			t.Errorf("expected %d lines for '%s', got %d", len(expected), query, len(results))
+			continue
+		}
+		for n, index := range expected {
+			if results[n].Index() != index {
+				t.Errorf("expected line %d for '%s' to be %d, got %d", n, query, index, results[n].Index())
+			}
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/nsf/termbox-go"
 )
@@ -15,24 +17,388 @@ var homedirFunc = homedir
 // Config holds all the data that can be configured in the
 // external configuran file
 type Config struct {
-	Action        map[string][]string `json:"Action"`
+	Action map[string][]string `json:"Action"`
+	// ActionAliases maps alternative names to action names, so that
+	// they can be used in Keymap and Action
+	ActionAliases map[string]string `json:"ActionAliases"`
+	// KeyNames maps the raw sequences that the terminal sends for keys
+	// that termbox doesn't know (e.g. "\u001b[15;2~") to names that
+	// can be used in Keymap. See peco.CaptureKey
+	KeyNames map[string]string `json:"KeyNames"`
 	// Keymap used to be directly responsible for dispatching
 	// events against user input, but since then this has changed
 	// into something that just records the user's config input
 	Keymap        map[string]string `json:"Keymap"`
-	Matcher       string   `json:"Matcher"`
-	Style         StyleSet `json:"Style"`
+	Matcher       string            `json:"Matcher"`
+	Style         StyleSet          `json:"Style"`
 	CustomMatcher map[string][]string
-	Prompt        string   `json:"Prompt"`
+	Prompt        string `json:"Prompt"`
+	// OverScan is the number of lines above and below the current
+	// page that are laid out in advance, to make scrolling faster
+	OverScan int `json:"OverScan"`
+	// Directory controls how directory-like lines are displayed
+	Directory DirectoryConfig `json:"Directory"`
+	// Border controls the border drawn around peco
+	Border BorderConfig `json:"Border"`
+	// DrillDownCommand is the command used by peco.DrillDown to list
+	// the children of the current line
+	DrillDownCommand []string `json:"DrillDownCommand"`
+	// ForEachCommand is the command that peco.ForEachSelection runs
+	// for each of the selected lines. "{}" is replaced with the line
+	ForEachCommand string `json:"ForEachCommand"`
+	// PaneCommand is the command that peco.OpenInPane runs in a new
+	// pane of the terminal multiplexer. "{}" is replaced with the line
+	PaneCommand string `json:"PaneCommand"`
+	// PreviewCommand is the command used to create the preview of
+	// a line, when the input doesn't provide one
+	PreviewCommand []string `json:"PreviewCommand"`
+	// PreviewDelay is the number of milliseconds that the current
+	// line must stay the same before PreviewCommand is run for it
+	PreviewDelay int `json:"PreviewDelay"`
+	// MaxPreviewProcesses limits the number of PreviewCommand
+	// processes that run at the same time. 0 means there's no limit
+	MaxPreviewProcesses int `json:"MaxPreviewProcesses"`
+	// BackspaceOnEmptyQuery specifies what happens when Backspace is
+	// pressed while the query is empty
+	BackspaceOnEmptyQuery string `json:"BackspaceOnEmptyQuery"`
+	// CancelSteps are what peco.Cancel tries to do, in order, until
+	// one of them applies. See CancelKeySequence and friends
+	CancelSteps []string `json:"CancelSteps"`
+	// OutputPrefix and OutputSuffix are wrapped around each line
+	// that is printed upon exiting
+	OutputPrefix string `json:"OutputPrefix"`
+	OutputSuffix string `json:"OutputSuffix"`
+	// OutputEscape specifies how the lines are escaped when they are
+	// wrapped with OutputPrefix and OutputSuffix. See OutputEscapeSuffix
+	// and friends
+	OutputEscape string `json:"OutputEscape"`
+	// OutputSeparator, if set, is used to join the output lines
+	// instead of newlines
+	OutputSeparator string `json:"OutputSeparator"`
+	// WrapSelectionJump makes peco.NextSelection/peco.PreviousSelection
+	// wrap around when there are no more selected lines in that direction
+	WrapSelectionJump bool `json:"WrapSelectionJump"`
+	// QueryMask, if set, is displayed in place of each character
+	// in the query. Matching is still done using the actual query
+	QueryMask string `json:"QueryMask"`
+	// CopyViewScope specifies which lines peco.CopyView copies
+	CopyViewScope string `json:"CopyViewScope"`
+	// MatchPrefixLength limits matching to the first N characters of
+	// each line. 0 means the entire line is matched
+	MatchPrefixLength int `json:"MatchPrefixLength"`
+	// ShowLineNumbers displays the position of each line in the input
+	ShowLineNumbers bool `json:"ShowLineNumbers"`
+	// LineStyles are applied to lines that match their patterns.
+	// They are evaluated in order, and the first match wins
+	LineStyles []LineStyle `json:"LineStyles"`
+	// ScoreStyles are applied to lines according to how well they
+	// matched the query, when none of LineStyles apply. They are
+	// evaluated in order, and the first one whose Threshold the score
+	// reaches wins
+	ScoreStyles []ScoreStyle `json:"ScoreStyles"`
+	// CaseFolding specifies how the IgnoreCase matcher compares
+	// characters. See CaseFoldingSimple and CaseFoldingFull
+	CaseFolding string `json:"CaseFolding"`
+	// NormalizePaths makes the IgnoreCase matcher treat backslashes
+	// and slashes as the same character, so that paths match
+	// regardless of their path separators
+	NormalizePaths bool `json:"NormalizePaths"`
+	// DescriptionSeparator, if set, splits each line into the text
+	// being matched and a description that is only displayed
+	DescriptionSeparator string `json:"DescriptionSeparator"`
+	// EmptyQuery controls what is displayed while the query is empty
+	EmptyQuery EmptyQueryConfig `json:"EmptyQuery"`
+	// RelativePath controls how peco.CopyRelativePath converts lines
+	RelativePath RelativePathConfig `json:"RelativePath"`
+	// MaxQueryLength is the maximum number of characters in the query.
+	// Any further input is ignored. 0 means there is no limit
+	MaxQueryLength int `json:"MaxQueryLength"`
+	// ColumnDelimiter separates the columns used by peco.CycleSortColumn.
+	// By default, columns are separated by whitespace
+	ColumnDelimiter string `json:"ColumnDelimiter"`
+	// NumericColumns maps names to columns (starting from 1) that can
+	// be compared with numbers in the query, e.g. "size>1000"
+	NumericColumns map[string]int `json:"NumericColumns"`
+	// AutoDelimiter detects the column delimiter from the first lines
+	// of the input, unless ColumnDelimiter is set
+	AutoDelimiter bool `json:"AutoDelimiter"`
+	// TieBreak specifies how lines that are otherwise equal in the
+	// displayed order are ordered. See TieBreakIndex, TieBreakLength
+	// and TieBreakLexical
+	TieBreak string `json:"TieBreak"`
+	// ShowWhitespace displays tabs and trailing spaces using the
+	// Whitespace style. The lines themselves are not modified
+	ShowWhitespace bool `json:"ShowWhitespace"`
+	// HighlightFullLine fills the rest of each row with the style of
+	// the line (e.g. Selected for the current line). When false (the
+	// default), only the text of the line is drawn in its style
+	HighlightFullLine bool `json:"HighlightFullLine"`
+	// SaveConfigPath is the file that peco.SaveConfig writes to
+	SaveConfigPath string `json:"SaveConfigPath"`
+	// SortColumn is the column (starting from 1) that the lines are
+	// sorted by at startup, or 0 to keep the order of the input
+	SortColumn     int  `json:"SortColumn"`
+	SortDescending bool `json:"SortDescending"`
+	// These are the initial states of the toggles (e.g. the one of
+	// peco.ToggleRelativeNumbers), so that peco.SaveConfig can keep them
+	RelativeNumbers   bool `json:"RelativeNumbers"`
+	ShowInfoLine      bool `json:"ShowInfoLine"`
+	Compact           bool `json:"Compact"`
+	MatchDescription  bool `json:"MatchDescription"`
+	ShowOutputPreview bool `json:"ShowOutputPreview"`
+	ShowPreview       bool `json:"ShowPreview"`
+	PreviewToMatch    bool `json:"PreviewToMatch"`
+	// Fuzzy controls how the Fuzzy matcher accepts lines
+	Fuzzy FuzzyConfig `json:"Fuzzy"`
+	// Like controls how the Like matcher compares characters
+	Like LikeConfig `json:"Like"`
+	// UnmatchedPinnedLines specifies whether pinned lines that don't
+	// match the query are displayed. See UnmatchedPinnedShow and
+	// UnmatchedPinnedHide
+	UnmatchedPinnedLines string `json:"UnmatchedPinnedLines"`
+	// UnderlineMatches underlines the matched characters instead of
+	// displaying them using the Matched style
+	UnderlineMatches bool `json:"UnderlineMatches"`
+	// FlashDuration is the number of milliseconds that peco.FlashMatches
+	// displays the matches using the Flash style
+	FlashDuration int `json:"FlashDuration"`
+	// NoMatchSignal specifies how peco signals that the query stopped
+	// matching anything. See NoMatchNone, NoMatchBell and NoMatchFlash
+	NoMatchSignal string `json:"NoMatchSignal"`
+	// ViewportScopeLines is the number of lines before and after the
+	// current line that are matched after peco.ToggleViewportScope
+	ViewportScopeLines int `json:"ViewportScopeLines"`
+	// SelectionOnQueryChange specifies what happens to the selection
+	// when the query changes. See SelectionClear and SelectionPersist
+	SelectionOnQueryChange string `json:"SelectionOnQueryChange"`
+	// VisualSelect specifies whether the lines selected after
+	// peco.StartVisualSelect are added to the existing selection, or
+	// replace it. See VisualSelectAdd and VisualSelectReplace
+	VisualSelect string `json:"VisualSelect"`
+	// SimilarLines specifies which lines peco.SelectSimilar selects
+	SimilarLines SimilarConfig `json:"SimilarLines"`
+	// SiblingDelimiter separates the parts of hierarchical lines,
+	// such as dotted keys, for peco.FilterBySiblingPrefix
+	SiblingDelimiter string `json:"SiblingDelimiter"`
+	// OnEmptyInput specifies what happens when the input ends without
+	// any lines. See EmptyInputExit, EmptyInputShowMessage and
+	// EmptyInputShowStderr
+	OnEmptyInput string `json:"OnEmptyInput"`
+	// EmptyInputMessage is displayed when the input ends without any
+	// lines and OnEmptyInput is EmptyInputShowMessage
+	EmptyInputMessage string `json:"EmptyInputMessage"`
+	// AcceptMode specifies what is done with the result when peco
+	// exits. See AcceptPrint and AcceptCopy
+	AcceptMode string `json:"AcceptMode"`
+	// NamedQueries are the queries that peco.LoadNamedQuery loads,
+	// by name
+	NamedQueries map[string]string `json:"NamedQueries"`
+	// ExportFormats are the formats that can be used with peco.ExportAs
+	ExportFormats map[string]ExportFormat `json:"ExportFormats"`
+	// InputEncoding is the encoding that the input is decoded from,
+	// e.g. "Shift_JIS". The default is UTF-8
+	InputEncoding string `json:"InputEncoding"`
+	// EncodeOutput makes the output be encoded back into InputEncoding
+	EncodeOutput bool `json:"EncodeOutput"`
+	// State controls whether the query and the current line are
+	// remembered between invocations on the same source
+	State StateConfig `json:"State"`
+	// QueryScroll controls how the query scrolls when it's wider than
+	// the prompt line
+	QueryScroll QueryScrollConfig `json:"QueryScroll"`
+}
+
+// These are the values that can be specified in CaseFolding
+const (
+	// CaseFoldingSimple compares characters one by one, ignoring case
+	CaseFoldingSimple = "simple"
+	// CaseFoldingFull folds the entire line before comparing, so that
+	// characters such as "ß" match their expanded forms ("ss")
+	CaseFoldingFull = "full"
+)
+
+// LineStyle describes the Style to be used for lines that match Pattern
+type LineStyle struct {
+	Pattern string `json:"Pattern"`
+	Style   Style  `json:"Style"`
+}
+
+// ScoreStyle describes the Style to be used for lines whose match score
+// (from 0 to 100) is at least Threshold
+type ScoreStyle struct {
+	Threshold int   `json:"Threshold"`
+	Style     Style `json:"Style"`
+}
+
+// These are the values that can be specified in CopyViewScope
+const (
+	CopyViewAll  = "all"
+	CopyViewPage = "page"
+)
+
+// These are the values that can be specified in BackspaceOnEmptyQuery
+const (
+	BackspaceNoop    = "noop"
+	BackspaceCancel  = "cancel"
+	BackspaceDrillUp = "drill-up"
+)
+
+// These are the values that can be specified in NoMatchSignal
+const (
+	// NoMatchNone doesn't signal anything (default)
+	NoMatchNone = "none"
+	// NoMatchBell rings the terminal bell
+	NoMatchBell = "bell"
+	// NoMatchFlash displays the prompt using the Flash style for
+	// FlashDuration milliseconds
+	NoMatchFlash = "flash"
+)
+
+// These are the values that can be specified in CancelSteps
+const (
+	// CancelKeySequence cancels a key sequence being typed
+	CancelKeySequence = "key-sequence"
+	// CancelCommandMode leaves the command mode of peco.ExecuteCommand
+	CancelCommandMode = "command-mode"
+	// CancelRangeMode stops selecting by range
+	CancelRangeMode = "range-mode"
+	// CancelForEach stops the commands run by peco.ForEachSelection
+	CancelForEach = "for-each"
+	// CancelModalBuffer closes peco.CommandPalette and peco.EnterPreview
+	CancelModalBuffer = "modal-buffer"
+	// CancelPreview hides the preview pane
+	CancelPreview = "preview"
+	// CancelQuery clears the query, if it's not empty
+	CancelQuery = "query"
+	// CancelExit exits with failure status
+	CancelExit = "exit"
+)
+
+// DirectoryConfig describes how lines that look like directories
+// are detected and displayed. It only affects the display: the
+// output is always the original line
+type DirectoryConfig struct {
+	Enable bool `json:"Enable"`
+	// Pattern is the regular expression that lines must match to be
+	// treated as directories. By default, lines with a trailing slash
+	Pattern string `json:"Pattern"`
+	// SortFirst makes directories appear before everything else
+	SortFirst bool `json:"SortFirst"`
+}
+
+// EmptyQueryConfig limits the number of lines displayed while the
+// query is empty, so that huge buffers are not displayed in their
+// entirety when it's unlikely to be useful
+type EmptyQueryConfig struct {
+	// Threshold is the number of lines in the buffer above which
+	// the limit applies. 0 means there is no limit
+	Threshold int `json:"Threshold"`
+	// Lines is the number of lines that are displayed when the
+	// limit applies. 0 means nothing is displayed
+	Lines int `json:"Lines"`
+}
+
+// BorderConfig describes the border that is drawn around peco
+type BorderConfig struct {
+	Enable bool `json:"Enable"`
+	// Chars are the characters used for the horizontal lines, the
+	// vertical lines, and the top-left, top-right, bottom-left and
+	// bottom-right corners, in that order
+	Chars string `json:"Chars"`
+}
+
+// StateConfig describes how the query and the current line are saved
+// when peco exits, and restored the next time it's run on the same
+// source (see --state-key)
+type StateConfig struct {
+	Enable bool `json:"Enable"`
+	// File is where the state is saved. The default is
+	// ~/.peco/state.json
+	File string `json:"File"`
+	// MaxKeys is the number of sources that are remembered. When
+	// there are more, the least recently used ones are forgotten.
+	// 0 means there's no limit
+	MaxKeys int `json:"MaxKeys"`
+}
+
+// QueryScrollConfig describes how the query scrolls horizontally, so
+// that the caret stays visible
+type QueryScrollConfig struct {
+	Enable bool `json:"Enable"`
+	// Margin is the number of cells of the query that are kept
+	// visible on each side of the caret, where there are any
+	Margin int `json:"Margin"`
+}
+
+// SimilarConfig describes which lines are similar to the current line
+// for peco.SelectSimilar
+type SimilarConfig struct {
+	// Field is the column (starting from 1, see ColumnDelimiter) that
+	// must be the same as in the current line
+	Field int `json:"Field"`
+	// Pattern, if set, is used instead of Field. It's a regular
+	// expression that lines must match, where the placeholders of
+	// PreviewCommand (e.g. "{1}") are replaced with the values from
+	// the current line, escaped so that they match literally
+	Pattern string `json:"Pattern"`
+}
+
+// FuzzyConfig holds the settings for the Fuzzy matcher
+type FuzzyConfig struct {
+	// RequireWordStart only accepts lines where the first character
+	// of the query matches at the start of a word
+	RequireWordStart bool `json:"RequireWordStart"`
+}
+
+// LikeConfig holds the settings for the Like matcher
+type LikeConfig struct {
+	// IgnoreCase makes the Like matcher compare characters ignoring case
+	IgnoreCase bool `json:"IgnoreCase"`
 }
 
 // NewConfig creates a new Config
 func NewConfig() *Config {
 	return &Config{
-		Keymap:  make(map[string]string),
-		Matcher: IgnoreCaseMatch,
-		Style:   NewStyleSet(),
-		Prompt:  "QUERY>",
+		Keymap:   make(map[string]string),
+		Matcher:  IgnoreCaseMatch,
+		Style:    NewStyleSet(),
+		Prompt:   "QUERY>",
+		OverScan: 5,
+		Directory: DirectoryConfig{
+			Pattern: "/$",
+		},
+		Border: BorderConfig{
+			Chars: "─│┌┐└┘",
+		},
+		State: StateConfig{
+			MaxKeys: 100,
+		},
+		SimilarLines: SimilarConfig{
+			Field: 1,
+		},
+		QueryScroll: QueryScrollConfig{
+			Enable: true,
+			Margin: 5,
+		},
+		PaneCommand:            "${EDITOR:-vi} {}",
+		BackspaceOnEmptyQuery:  BackspaceNoop,
+		CancelSteps:            []string{CancelForEach, CancelKeySequence, CancelCommandMode, CancelRangeMode, CancelModalBuffer, CancelExit},
+		WrapSelectionJump:      true,
+		CopyViewScope:          CopyViewAll,
+		CaseFolding:            CaseFoldingSimple,
+		MaxQueryLength:         1024,
+		UnmatchedPinnedLines:   UnmatchedPinnedShow,
+		SelectionOnQueryChange: SelectionClear,
+		VisualSelect:           VisualSelectAdd,
+		SiblingDelimiter:       ".",
+		OnEmptyInput:           EmptyInputExit,
+		AcceptMode:             AcceptPrint,
+		OutputEscape:           OutputEscapeSuffix,
+		EmptyInputMessage:      "No input (press Enter or Esc to exit)",
+		TieBreak:               TieBreakIndex,
+		FlashDuration:          500,
+		NoMatchSignal:          NoMatchNone,
+		ViewportScopeLines:     100,
 	}
 }
 
@@ -50,6 +416,155 @@ func (c *Config) ReadFilename(filename string) error {
 		return err
 	}
 
+	switch c.BackspaceOnEmptyQuery {
+	case BackspaceNoop, BackspaceCancel, BackspaceDrillUp:
+	default:
+		return fmt.Errorf("error: Invalid BackspaceOnEmptyQuery '%s'", c.BackspaceOnEmptyQuery)
+	}
+
+	canExit := false
+	for _, name := range c.CancelSteps {
+		if _, ok := cancelSteps[name]; !ok {
+			return fmt.Errorf("error: Invalid CancelSteps '%s'", name)
+		}
+		canExit = canExit || name == CancelExit
+	}
+	if !canExit {
+		// There would be no way to quit
+		return fmt.Errorf("error: CancelSteps must include '%s'", CancelExit)
+	}
+
+	if _, err := lookupEncoding(c.InputEncoding); err != nil {
+		return err
+	}
+
+	switch c.NoMatchSignal {
+	case NoMatchNone, NoMatchBell, NoMatchFlash:
+	default:
+		return fmt.Errorf("error: Invalid NoMatchSignal '%s'", c.NoMatchSignal)
+	}
+
+	switch c.CopyViewScope {
+	case CopyViewAll, CopyViewPage:
+	default:
+		return fmt.Errorf("error: Invalid CopyViewScope '%s'", c.CopyViewScope)
+	}
+
+	switch c.CaseFolding {
+	case CaseFoldingSimple, CaseFoldingFull:
+	default:
+		return fmt.Errorf("error: Invalid CaseFolding '%s'", c.CaseFolding)
+	}
+
+	switch c.UnmatchedPinnedLines {
+	case UnmatchedPinnedShow, UnmatchedPinnedHide:
+	default:
+		return fmt.Errorf("error: Invalid UnmatchedPinnedLines '%s'", c.UnmatchedPinnedLines)
+	}
+
+	for name, f := range c.ExportFormats {
+		switch f.Target {
+		case "", ExportToClipboard, ExportToStdout:
+		default:
+			return fmt.Errorf("error: Invalid Target '%s' for ExportFormat '%s'", f.Target, name)
+		}
+	}
+
+	switch c.SelectionOnQueryChange {
+	case SelectionClear, SelectionPersist:
+	default:
+		return fmt.Errorf("error: Invalid SelectionOnQueryChange '%s'", c.SelectionOnQueryChange)
+	}
+
+	if c.Border.Enable && utf8.RuneCountInString(c.Border.Chars) != 6 {
+		return fmt.Errorf("error: Invalid Border chars '%s'", c.Border.Chars)
+	}
+
+	switch c.VisualSelect {
+	case VisualSelectAdd, VisualSelectReplace:
+	default:
+		return fmt.Errorf("error: Invalid VisualSelect '%s'", c.VisualSelect)
+	}
+
+	switch c.TieBreak {
+	case TieBreakIndex, TieBreakLength, TieBreakLexical:
+	default:
+		return fmt.Errorf("error: Invalid TieBreak '%s'", c.TieBreak)
+	}
+
+	switch c.OnEmptyInput {
+	case EmptyInputExit, EmptyInputShowMessage, EmptyInputShowStderr:
+	default:
+		return fmt.Errorf("error: Invalid OnEmptyInput '%s'", c.OnEmptyInput)
+	}
+
+	for _, ss := range c.ScoreStyles {
+		if ss.Threshold < 0 || ss.Threshold > 100 {
+			return fmt.Errorf("error: Invalid ScoreStyles Threshold %d", ss.Threshold)
+		}
+	}
+
+	for name, column := range c.NumericColumns {
+		if column < 1 {
+			return fmt.Errorf("error: Invalid NumericColumns column %d for '%s'", column, name)
+		}
+	}
+
+	if c.SimilarLines.Pattern == "" && c.SimilarLines.Field < 1 {
+		return fmt.Errorf("error: Invalid SimilarLines Field %d", c.SimilarLines.Field)
+	}
+
+	if c.ViewportScopeLines < 0 {
+		return fmt.Errorf("error: Invalid ViewportScopeLines %d", c.ViewportScopeLines)
+	}
+
+	if c.SortColumn < 0 {
+		return fmt.Errorf("error: Invalid SortColumn %d", c.SortColumn)
+	}
+
+	if c.QueryScroll.Margin < 0 {
+		return fmt.Errorf("error: Invalid QueryScroll Margin %d", c.QueryScroll.Margin)
+	}
+
+	switch c.AcceptMode {
+	case AcceptPrint, AcceptCopy:
+	default:
+		return fmt.Errorf("error: Invalid AcceptMode '%s'", c.AcceptMode)
+	}
+
+	switch c.OutputEscape {
+	case OutputEscapeSuffix, OutputEscapeNone, OutputEscapeDoubleQuote, OutputEscapeSingleQuote:
+	default:
+		return fmt.Errorf("error: Invalid OutputEscape '%s'", c.OutputEscape)
+	}
+
+	return c.checkNamedQueries()
+}
+
+// checkNamedQueries makes sure that every peco.LoadNamedQuery in the
+// key bindings, combined actions and aliases refers to one of the
+// NamedQueries
+func (c *Config) checkNamedQueries() error {
+	names := []string{}
+	for _, name := range c.Keymap {
+		names = append(names, name)
+	}
+	for _, l := range c.Action {
+		names = append(names, l...)
+	}
+	for _, name := range c.ActionAliases {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		base, arg, ok := splitActionArgument(name)
+		if !ok || base != "peco.LoadNamedQuery" {
+			continue
+		}
+		if _, ok := c.NamedQueries[arg]; !ok {
+			return fmt.Errorf("error: Unknown named query '%s' in %s", arg, name)
+		}
+	}
 	return nil
 }
 
@@ -93,16 +608,47 @@ type StyleSet struct {
 	Selected       Style `json:"Selected"`
 	Query          Style `json:"Query"`
 	Matched        Style `json:"Matched"`
+	Directory      Style `json:"Directory"`
+	Description    Style `json:"Description"`
+	OutputPreview  Style `json:"OutputPreview"`
+	Whitespace     Style `json:"Whitespace"`
+	Pinned         Style `json:"Pinned"`
+	// PinnedUnmatched is used for pinned lines that don't match the query
+	PinnedUnmatched Style `json:"PinnedUnmatched"`
+	Border          Style `json:"Border"`
+	// Flash is used for the matches while peco.FlashMatches is in effect,
+	// and for the prompt when NoMatchSignal is NoMatchFlash
+	Flash Style `json:"Flash"`
+	// MatchedTerms, if specified, are used instead of Matched to
+	// highlight each of the query terms
+	MatchedTerms []Style `json:"MatchedTerms"`
+}
+
+// MatchedTerm returns the style used to highlight the n-th query term.
+// Matched is used when there are not enough MatchedTerms
+func (s StyleSet) MatchedTerm(n int) Style {
+	if n < len(s.MatchedTerms) {
+		return s.MatchedTerms[n]
+	}
+	return s.Matched
 }
 
 // NewStyleSet creates a new StyleSet struct
 func NewStyleSet() StyleSet {
 	return StyleSet{
-		Basic:          Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault},
-		SavedSelection: Style{fg: termbox.ColorBlack | termbox.AttrBold, bg: termbox.ColorCyan},
-		Selected:       Style{fg: termbox.ColorDefault | termbox.AttrUnderline, bg: termbox.ColorMagenta},
-		Query:          Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault},
-		Matched:        Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault},
+		Basic:           Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault},
+		SavedSelection:  Style{fg: termbox.ColorBlack | termbox.AttrBold, bg: termbox.ColorCyan},
+		Selected:        Style{fg: termbox.ColorDefault | termbox.AttrUnderline, bg: termbox.ColorMagenta},
+		Query:           Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault},
+		Matched:         Style{fg: termbox.ColorCyan, bg: termbox.ColorDefault},
+		Directory:       Style{fg: termbox.ColorBlue | termbox.AttrBold, bg: termbox.ColorDefault},
+		Description:     Style{fg: termbox.ColorBlack | termbox.AttrBold, bg: termbox.ColorDefault},
+		OutputPreview:   Style{fg: termbox.ColorYellow, bg: termbox.ColorDefault},
+		Whitespace:      Style{fg: termbox.ColorBlack | termbox.AttrBold, bg: termbox.ColorDefault},
+		Pinned:          Style{fg: termbox.ColorYellow | termbox.AttrBold, bg: termbox.ColorDefault},
+		PinnedUnmatched: Style{fg: termbox.ColorBlack | termbox.AttrBold, bg: termbox.ColorDefault},
+		Border:          Style{fg: termbox.ColorDefault, bg: termbox.ColorDefault},
+		Flash:           Style{fg: termbox.ColorBlack | termbox.AttrBold, bg: termbox.ColorYellow},
 	}
 }
 
@@ -122,6 +668,51 @@ func (s *Style) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
+// MarshalJSON satisfies json.Marshaler. The style is written in the
+// same format that UnmarshalJSON reads
+func (s Style) MarshalJSON() ([]byte, error) {
+	return json.Marshal(styleToStrings(s))
+}
+
+func styleToStrings(s Style) []string {
+	fgAttrs := termbox.AttrBold | termbox.AttrUnderline | termbox.AttrReverse
+	bgAttrs := termbox.AttrBold
+
+	raw := []string{}
+	if name, ok := attributeName(stringToFg, s.fg&^fgAttrs); ok {
+		raw = append(raw, name)
+	}
+	if name, ok := attributeName(stringToBg, s.bg&^bgAttrs); ok {
+		raw = append(raw, name)
+	}
+	raw = append(raw, attributeNames(stringToFgAttr, s.fg)...)
+	raw = append(raw, attributeNames(stringToBgAttr, s.bg)...)
+	return raw
+}
+
+// attributeName returns the name of the color `a` in `names`
+func attributeName(names map[string]termbox.Attribute, a termbox.Attribute) (string, bool) {
+	for name, v := range names {
+		if v == a {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// attributeNames returns the names of the attributes in `names` that
+// are set in `a`, sorted so that the result is stable
+func attributeNames(names map[string]termbox.Attribute, a termbox.Attribute) []string {
+	list := []string{}
+	for name, v := range names {
+		if a&v != 0 {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
 func stringsToStyle(raw []string) *Style {
 	style := &Style{
 		fg: termbox.ColorDefault,
@@ -157,54 +748,85 @@ func stringsToStyle(raw []string) *Style {
 
 var _locateRcfileIn = locateRcfileIn
 
+// ConfigNameEnv is the environment variable that specifies the name of
+// the config file that LocateRcfile looks for, instead of config.json
+const ConfigNameEnv = "PECO_CONFIG_NAME"
+
+// rcfileBasename returns the name of the config file to look for
+func rcfileBasename() string {
+	if name := os.Getenv(ConfigNameEnv); name != "" {
+		return name
+	}
+	return "config.json"
+}
+
 func locateRcfileIn(dir string) (string, error) {
-	const basename = "config.json"
-	file := filepath.Join(dir, basename)
+	file := filepath.Join(dir, rcfileBasename())
 	if _, err := os.Stat(file); err != nil {
 		return "", err
 	}
 	return file, nil
 }
 
-// LocateRcfile attempts to find the config file in various locations
-func LocateRcfile() (string, error) {
+// ConfigOrderEnv is the environment variable that specifies the order in
+// which LocateRcfile looks for the config file. See ConfigOrderXDG and
+// ConfigOrderHome. It can't be specified in the config file itself
+const ConfigOrderEnv = "PECO_CONFIG_ORDER"
+
+// These are the values for ConfigOrderEnv
+const (
+	// ConfigOrderXDG looks in the XDG locations before ~/.peco (default)
+	ConfigOrderXDG = "xdg"
+	// ConfigOrderHome looks in ~/.peco before the XDG locations
+	ConfigOrderHome = "home"
+)
+
+// rcfileDirs returns the directories that LocateRcfile looks into, in order
+func rcfileDirs(order string) []string {
 	// http://standards.freedesktop.org/basedir-spec/basedir-spec-latest.html
 	//
-	// Try in this order:
+	// By default, try in this order:
 	//	  $XDG_CONFIG_HOME/peco/config.json
 	//    $XDG_CONFIG_DIR/peco/config.json (where XDG_CONFIG_DIR is listed in $XDG_CONFIG_DIRS)
 	//	  ~/.peco/config.json
 
 	home, uErr := homedirFunc()
 
+	var dirs []string
 	// Try dir supplied via env var
 	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
-		file, err := _locateRcfileIn(filepath.Join(dir, "peco"))
-		if err == nil {
-			return file, nil
-		}
+		dirs = append(dirs, filepath.Join(dir, "peco"))
 	} else if uErr == nil { // silently ignore failure for homedir()
 		// Try "default" XDG location, is user is available
-		file, err := _locateRcfileIn(filepath.Join(home, ".config", "peco"))
-		if err == nil {
-			return file, nil
-		}
+		dirs = append(dirs, filepath.Join(home, ".config", "peco"))
 	}
 
 	// this standard does not take into consideration windows (duh)
 	// while the spec says use ":" as the separator, Go provides us
 	// with filepath.ListSeparator, so use it
-	if dirs := os.Getenv("XDG_CONFIG_DIRS"); dirs != "" {
-		for _, dir := range strings.Split(dirs, fmt.Sprintf("%c", filepath.ListSeparator)) {
-			file, err := _locateRcfileIn(filepath.Join(dir, "peco"))
-			if err == nil {
-				return file, nil
-			}
+	if xdgDirs := os.Getenv("XDG_CONFIG_DIRS"); xdgDirs != "" {
+		for _, dir := range strings.Split(xdgDirs, fmt.Sprintf("%c", filepath.ListSeparator)) {
+			dirs = append(dirs, filepath.Join(dir, "peco"))
 		}
 	}
 
 	if uErr == nil { // silently ignore failure for homedir()
-		file, err := _locateRcfileIn(filepath.Join(home, ".peco"))
+		dir := filepath.Join(home, ".peco")
+		if order == ConfigOrderHome {
+			dirs = append([]string{dir}, dirs...)
+		} else {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// LocateRcfile attempts to find the config file in various locations.
+// The order can be changed via the environment variable PECO_CONFIG_ORDER,
+// and the name of the file via PECO_CONFIG_NAME
+func LocateRcfile() (string, error) {
+	for _, dir := range rcfileDirs(os.Getenv(ConfigOrderEnv)) {
+		file, err := _locateRcfileIn(dir)
 		if err == nil {
 			return file, nil
 		}
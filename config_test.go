@@ -35,6 +35,59 @@ func TestReadRC(t *testing.T) {
 	t.Logf("%#q", cfg)
 }
 
+func TestReadLineStyles(t *testing.T) {
+	txt := `
+{
+	"LineStyles": [
+		{ "Pattern": "ERROR", "Style": ["red", "bold"] },
+		{ "Pattern": "WARN", "Style": ["yellow"] }
+	]
+}
+`
+	cfg := NewConfig()
+	if err := json.Unmarshal([]byte(txt), cfg); err != nil {
+		t.Fatalf("Error unmarshaling json: %s", err)
+	}
+
+	if len(cfg.LineStyles) != 2 {
+		t.Fatalf("Expected 2 LineStyles, got %d", len(cfg.LineStyles))
+	}
+
+	expected := Style{fg: termbox.ColorRed | termbox.AttrBold, bg: termbox.ColorDefault}
+	if cfg.LineStyles[0].Pattern != "ERROR" || cfg.LineStyles[0].Style != expected {
+		t.Errorf("Expected ERROR => %#v, got %#v", expected, cfg.LineStyles[0])
+	}
+}
+
+func TestCheckNamedQueries(t *testing.T) {
+	txt := `
+{
+	"NamedQueries": {
+		"errors": "ERROR|FATAL",
+		"todo": "TODO"
+	},
+	"Keymap": {
+		"M-1": "peco.LoadNamedQuery(errors)"
+	},
+	"Action": {
+		"foo.Todo": ["peco.DeleteAll", "peco.LoadNamedQuery(todo)"]
+	}
+}
+`
+	cfg := NewConfig()
+	if err := json.Unmarshal([]byte(txt), cfg); err != nil {
+		t.Fatalf("Error unmarshaling json: %s", err)
+	}
+	if err := cfg.checkNamedQueries(); err != nil {
+		t.Errorf("expected the named queries to be found, got %s", err)
+	}
+
+	cfg.ActionAliases = map[string]string{"warn": "peco.LoadNamedQuery(warnings)"}
+	if err := cfg.checkNamedQueries(); err == nil {
+		t.Errorf("expected an unknown named query to be an error")
+	}
+}
+
 type stringsToStyleTest struct {
 	strings []string
 	style   *Style
@@ -121,4 +174,154 @@ func TestLocateRcfile(t *testing.T) {
 	i = 0
 	LocateRcfile()
 
+	// ~/.peco comes first when preferred
+	os.Setenv(ConfigOrderEnv, ConfigOrderHome)
+	defer os.Unsetenv(ConfigOrderEnv)
+	expected = append([]string{filepath.Join(dir, ".peco")}, expected[:len(expected)-1]...)
+	i = 0
+	LocateRcfile()
+}
+
+func TestLocateRcfileName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"config.json", "peco-corp.json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatalf("Failed to create %s: %s", name, err)
+		}
+	}
+
+	if file, err := locateRcfileIn(dir); err != nil || file != filepath.Join(dir, "config.json") {
+		t.Errorf("expected config.json to be found, got '%s' (%v)", file, err)
+	}
+
+	os.Setenv(ConfigNameEnv, "peco-corp.json")
+	defer os.Unsetenv(ConfigNameEnv)
+	if file, err := locateRcfileIn(dir); err != nil || file != filepath.Join(dir, "peco-corp.json") {
+		t.Errorf("expected peco-corp.json to be found, got '%s' (%v)", file, err)
+	}
+
+	os.Setenv(ConfigNameEnv, "missing.json")
+	if _, err := locateRcfileIn(dir); err == nil {
+		t.Errorf("expected missing.json to not be found")
+	}
+}
+
+func TestStyleMarshalJSON(t *testing.T) {
+	styles := []Style{
+		{fg: termbox.ColorRed | termbox.AttrBold | termbox.AttrUnderline, bg: termbox.ColorBlue},
+		{fg: termbox.ColorDefault, bg: termbox.ColorYellow | termbox.AttrBold},
+		NewStyleSet().Selected,
+	}
+	for _, style := range styles {
+		buf, err := json.Marshal(style)
+		if err != nil {
+			t.Errorf("Failed to marshal %#v: %s", style, err)
+			continue
+		}
+
+		var s Style
+		if err := json.Unmarshal(buf, &s); err != nil {
+			t.Errorf("Failed to unmarshal %s: %s", buf, err)
+			continue
+		}
+		if s != style {
+			t.Errorf("expected %s to round trip to %#v, got %#v", buf, style, s)
+		}
+	}
+}
+
+func TestReadFilenameInvalidNumbers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-invalid")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, txt := range []string{
+		`{"ViewportScopeLines": -1}`,
+		`{"QueryScroll": {"Margin": -1}}`,
+		`{"SortColumn": -1}`,
+	} {
+		filename := filepath.Join(dir, "config.json")
+		if err := ioutil.WriteFile(filename, []byte(txt), 0644); err != nil {
+			t.Fatalf("Failed to write config: %s", err)
+		}
+		if err := NewConfig().ReadFilename(filename); err == nil {
+			t.Errorf("expected %s to be rejected", txt)
+		}
+	}
+}
+
+func TestSaveConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-saveconfig")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rcfile := filepath.Join(dir, "rc.json")
+	if err := ioutil.WriteFile(rcfile, []byte(`{"Prompt": "[rc]", "Matcher": "CaseSensitive"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %s", err)
+	}
+
+	c := newTestCtx()
+	if err := c.ReadConfig(rcfile); err != nil {
+		t.Fatalf("Failed to read config: %s", err)
+	}
+	// command line options
+	c.SetPrompt([]rune("[flag]"))
+	c.SetOutputPrefix("flag")
+
+	filename := filepath.Join(dir, "config.json")
+	if err := c.SaveConfig(filename); err != nil {
+		t.Fatalf("Failed to save config: %s", err)
+	}
+	cfg := NewConfig()
+	if err := cfg.ReadFilename(filename); err != nil {
+		t.Fatalf("Failed to read saved config: %s", err)
+	}
+	if cfg.Prompt != "[rc]" || cfg.OutputPrefix != "" || cfg.Matcher != CaseSensitiveMatch {
+		t.Errorf("expected the config file to be saved without the command line options, got prompt = %s, prefix = %s, matcher = %s", cfg.Prompt, cfg.OutputPrefix, cfg.Matcher)
+	}
+
+	// settings changed while running
+	c.switchMatcher(c.matcherIndex(RegexpMatch))
+	c.sortColumn = 2
+	c.sortDescending = true
+	c.relativeNumbers = true
+	c.showPreview = true
+	if err := c.SaveConfig(filename); err != nil {
+		t.Fatalf("Failed to save config: %s", err)
+	}
+
+	cfg = NewConfig()
+	if err := cfg.ReadFilename(filename); err != nil {
+		t.Fatalf("Failed to read saved config: %s", err)
+	}
+	if cfg.Matcher != RegexpMatch || cfg.Prompt != "[rc]" {
+		t.Errorf("expected the runtime settings to be saved, got matcher = %s, prompt = %s", cfg.Matcher, cfg.Prompt)
+	}
+	if cfg.Style.Selected != NewStyleSet().Selected {
+		t.Errorf("expected the styles to be saved, got %#v", cfg.Style.Selected)
+	}
+	if cfg.SortColumn != 2 || !cfg.SortDescending {
+		t.Errorf("expected the sort order to be saved, got column = %d, descending = %t", cfg.SortColumn, cfg.SortDescending)
+	}
+	if !cfg.RelativeNumbers || !cfg.ShowPreview || cfg.Compact {
+		t.Errorf("expected the toggles to be saved, got %#v", cfg)
+	}
+
+	// The saved settings are in effect when they are read again
+	c = newTestCtx()
+	if err := c.ReadConfig(filename); err != nil {
+		t.Fatalf("Failed to read saved config: %s", err)
+	}
+	if c.sortColumn != 2 || !c.sortDescending || !c.relativeNumbers || !c.showPreview {
+		t.Errorf("expected the saved toggles to be restored")
+	}
 }
@@ -5,8 +5,11 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type CtxOptions interface {
@@ -42,6 +45,141 @@ type Ctx struct {
 	CurrentMatcher      int
 	ExitStatus          int
 	selectionRangeStart int
+	// lastQuery is the query the last time ExecQuery was called
+	lastQuery string
+	// inputDone is set once the input has been read to the end. It's
+	// protected by bufferMutex
+	inputDone bool
+	// sourceStderr is what the command started by StartSourceCommand
+	// wrote to stderr, once it has exited. It's protected by bufferMutex
+	sourceStderr string
+
+	// commandMode is set while the query line is being used to enter
+	// a command template for peco.ExecuteCommand/peco.PipeSelection
+	commandMode CommandKind
+	savedQuery  []rune
+	lastCommand *ExternalCommand
+
+	directoryRegexp *regexp.Regexp
+	lineStyles      []lineStyle
+
+	// frames holds the buffers that have been replaced via
+	// PushBuffer (e.g. peco.DrillDown)
+	frames []bufferFrame
+	// bufferMutex protects lines and frames, which the reader appends
+	// to while the buffer may be replaced via PushBuffer
+	bufferMutex sync.Mutex
+
+	// relativeNumbers is set when line numbers should be displayed as
+	// the distance from the current line
+	relativeNumbers bool
+
+	// showInfoLine is set when the description of each line is
+	// displayed on a line of its own, below the line
+	showInfoLine bool
+
+	// logger writes events to the file specified by --log. It is nil
+	// (i.e. discards everything) unless the option is specified
+	logger *eventLogger
+
+	// bookmarks are the selections saved by peco.BookmarkSelection
+	bookmarks map[string]Selection
+
+	// scopeCenter is the index of the line that the lines being
+	// matched are centered around (see peco.ToggleViewportScope), or
+	// -1 if the entire buffer is matched
+	scopeCenter int
+
+	// flashUntil is when the matches stop being displayed using the
+	// Flash style (see peco.FlashMatches)
+	flashUntil time.Time
+
+	// resultsLocked is set when the lines are not matched again when
+	// the query changes (see peco.LockResults)
+	resultsLocked bool
+
+	// noMatch is set while the query matches nothing, so that
+	// NoMatchSignal is only given when it starts to
+	noMatch bool
+	// promptFlashUntil is when the prompt stops being displayed
+	// using the Flash style (see NoMatchSignal). Both are protected by
+	// mutex, which the view holds while drawing
+	promptFlashUntil time.Time
+
+	// compact is set when the status line is hidden, and the prompt is
+	// minimized, so that more lines fit in the screen
+	compact bool
+	// queryOffset is the first character of the query that is
+	// displayed, when it's scrolled (see QueryScroll)
+	queryOffset int
+
+	// matchDescription is set when the query is matched against the
+	// description of each line, as well as the line itself
+	matchDescription bool
+
+	// showOutputPreview is set when what would be printed upon
+	// accepting the current selection is displayed in the footer
+	showOutputPreview bool
+
+	// sortColumn is the column (starting from 1) that the lines are
+	// sorted by, or 0 if they are displayed in the input order
+	sortColumn     int
+	sortDescending bool
+	// detectedDelimiter is the column delimiter that the reader
+	// detected from the first lines of the input (see AutoDelimiter).
+	// It's protected by bufferMutex
+	detectedDelimiter string
+
+	// pinned holds the indices of the lines in the current buffer that
+	// are displayed above everything else (see peco.PinLine). Each
+	// buffer has its own, as the indices refer to its lines
+	pinned map[int]bool
+
+	// inputJSON is set when the input is a JSON array of lines along
+	// with their previews (see --input-json)
+	inputJSON   bool
+	showPreview bool
+	previews    *previewStore
+	// previewFocused is set when the movement actions scroll the
+	// preview pane by previewScroll lines, instead of moving the cursor
+	previewFocused bool
+	previewScroll  int
+	// previewToMatch is set when the preview is scrolled to the line
+	// number in the current line, which is highlighted
+	previewToMatch bool
+
+	// literalMatcher is the matcher that peco.ToggleRegexp goes back to
+	literalMatcher int
+
+	// previousMatcher is the matcher that peco.ToggleLastMatcher
+	// switches to, or -1 if the matcher hasn't been changed yet
+	previousMatcher int
+
+	// stateKey identifies the source of the input in the state file
+	// (see --state-key)
+	stateKey string
+
+	// forEachCancel stops peco.ForEachSelection while it's running.
+	// It's protected by mutex
+	forEachCancel chan struct{}
+
+	// exported is the output created by peco.ExportAs when its
+	// target is stdout
+	exported *string
+
+	// continueOutput is where peco.AcceptAndContinue writes to right
+	// away, or nil if the output has to wait until peco exits, in
+	// which case it's kept in continuedOutput
+	continueOutput  io.Writer
+	continuedOutput string
+
+	// fileConfig is the config as it was read by ReadConfig, before
+	// any command line options were applied, or nil if there was no
+	// config file. matcherSwitched is set once the matcher has been
+	// switched while running. peco.SaveConfig uses these so that it
+	// only saves what has been changed in peco
+	fileConfig      *Config
+	matcherSwitched bool
 
 	wait *sync.WaitGroup
 }
@@ -66,10 +204,54 @@ func NewCtx(o CtxOptions) *Ctx {
 			NewIgnoreCaseMatcher(o.EnableNullSep()),
 			NewCaseSensitiveMatcher(o.EnableNullSep()),
 			NewRegexpMatcher(o.EnableNullSep()),
+			NewFuzzyMatcher(o.EnableNullSep()),
+			NewLikeMatcher(o.EnableNullSep()),
 		},
 		0,
 		0,
 		NoSelectionRange,
+		"",
+		false,
+		"",
+		0,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		sync.Mutex{},
+		false,
+		false,
+		nil,
+		nil,
+		-1,
+		time.Time{},
+		false,
+		false,
+		time.Time{},
+		false,
+		0,
+		false,
+		false,
+		0,
+		false,
+		"",
+		nil,
+		false,
+		false,
+		newPreviewStore(),
+		false,
+		0,
+		false,
+		0,
+		-1,
+		"",
+		nil,
+		nil,
+		nil,
+		"",
+		nil,
+		false,
 		&sync.WaitGroup{},
 	}
 }
@@ -80,21 +262,94 @@ func (c *Ctx) ReadConfig(file string) error {
 	if err := c.config.ReadFilename(file); err != nil {
 		return err
 	}
+	fileConfig := *c.config
+	c.fileConfig = &fileConfig
 
 	if err := c.LoadCustomMatcher(); err != nil {
 		return err
 	}
 	c.SetCurrentMatcher(c.config.Matcher)
 
+	for _, m := range c.Matchers {
+		if pl, ok := m.(interface {
+			SetPrefixLength(int)
+		}); ok {
+			pl.SetPrefixLength(c.config.MatchPrefixLength)
+		}
+		if fm, ok := m.(*FuzzyMatcher); ok {
+			fm.SetRequireWordStart(c.config.Fuzzy.RequireWordStart)
+		}
+		if lm, ok := m.(*LikeMatcher); ok {
+			lm.SetIgnoreCase(c.config.Like.IgnoreCase)
+		}
+		if ic, ok := m.(*IgnoreCaseMatcher); ok {
+			ic.SetFullCaseFolding(c.config.CaseFolding == CaseFoldingFull)
+			ic.SetPathNormalization(c.config.NormalizePaths)
+		}
+	}
+
+	c.sortColumn = c.config.SortColumn
+	c.sortDescending = c.config.SortDescending
+	c.relativeNumbers = c.config.RelativeNumbers
+	c.showInfoLine = c.config.ShowInfoLine
+	c.compact = c.config.Compact
+	c.matchDescription = c.config.MatchDescription
+	c.showOutputPreview = c.config.ShowOutputPreview
+	c.showPreview = c.config.ShowPreview
+	c.previewToMatch = c.config.PreviewToMatch
+	c.applyDescriptionSeparator()
+
+	if c.config.Directory.Enable {
+		re, err := regexp.Compile(c.config.Directory.Pattern)
+		if err != nil {
+			return fmt.Errorf("error: Invalid Directory pattern: %s", err)
+		}
+		c.directoryRegexp = re
+	}
+
+	for _, ls := range c.config.LineStyles {
+		re, err := regexp.Compile(ls.Pattern)
+		if err != nil {
+			return fmt.Errorf("error: Invalid LineStyles pattern: %s", err)
+		}
+		c.lineStyles = append(c.lineStyles, lineStyle{re, ls.Style})
+	}
+
 	return nil
 }
 
+// applyDescriptionSeparator tells the matchers which part of each line
+// is the description, so that it's not matched. When the description
+// is being matched (see peco.ToggleMatchDescription), the entire line
+// is matched instead
+func (c *Ctx) applyDescriptionSeparator() {
+	sep := c.config.DescriptionSeparator
+	if c.matchDescription {
+		sep = ""
+	}
+	for _, m := range c.Matchers {
+		if ds, ok := m.(interface {
+			SetDescriptionSeparator(string)
+		}); ok {
+			ds.SetDescriptionSeparator(sep)
+		}
+	}
+}
+
 func (c *Ctx) IsBufferOverflowing() bool {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return c.isBufferOverflowing()
+}
+
+// isBufferOverflowing is IsBufferOverflowing for when bufferMutex is
+// already held
+func (c *Ctx) isBufferOverflowing() bool {
 	if c.bufferSize <= 0 {
 		return false
 	}
 
-	return len(c.lines) > c.bufferSize
+	return len(*c.rootBuffer()) > c.bufferSize
 }
 
 func (c *Ctx) IsRangeMode() bool {
@@ -136,13 +391,129 @@ func (c *Ctx) WaitDone() {
 }
 
 func (c *Ctx) ExecQuery() bool {
+	if c.IsCommandMode() {
+		// The query line contains a command template, not a query
+		c.Refresh()
+		return true
+	}
+	if c.resultsLocked {
+		// Keep displaying the same lines until they're unlocked
+		c.Refresh()
+		return true
+	}
+
+	c.queryChanged()
+	c.logger.logf(LogInfo, "query", "query", string(c.query))
+
 	if len(c.query) > 0 {
 		c.SendQuery(string(c.query))
 		return true
 	}
+
+	// Nothing needs to be matched, but the filter still needs to know,
+	// so that the results of the previous query are discarded if it's
+	// still being matched
+	c.mutex.Lock()
+	c.noMatch = false
+	c.mutex.Unlock()
+	c.SendQuery("")
 	return false
 }
 
+// IsDirectory returns true if the line looks like a directory, and
+// directories should be displayed differently
+func (c *Ctx) IsDirectory(m Match) bool {
+	return c.directoryRegexp != nil && c.directoryRegexp.MatchString(m.Line())
+}
+
+type lineStyle struct {
+	re    *regexp.Regexp
+	style Style
+}
+
+// LineStyle returns the style from LineStyles or ScoreStyles that
+// applies to the line. Returns nil if none of them do
+func (c *Ctx) LineStyle(m Match) *Style {
+	for _, ls := range c.lineStyles {
+		if ls.re.MatchString(m.Line()) {
+			return &ls.style
+		}
+	}
+
+	if score, ok := matchScore(m); ok {
+		for n, ss := range c.config.ScoreStyles {
+			if score >= ss.Threshold {
+				return &c.config.ScoreStyles[n].Style
+			}
+		}
+	}
+	return nil
+}
+
+// orderMatches returns the matches in the order that they should be
+// displayed. The original slice is not modified
+func (c *Ctx) orderMatches(matches []Match) []Match {
+	dirsFirst := c.directoryRegexp != nil && c.config.Directory.SortFirst
+	tieBreak := c.config.TieBreak != "" && c.config.TieBreak != TieBreakIndex
+	if !dirsFirst && c.sortColumn == 0 && !tieBreak {
+		return c.pinMatches(matches)
+	}
+
+	// Each sort is stable, so the ones that come later take precedence
+	ordered := make([]Match, len(matches))
+	copy(ordered, matches)
+	if tieBreak {
+		sort.Stable(tieBreaker{ordered, c.config.TieBreak})
+	}
+	if c.sortColumn > 0 {
+		sort.Stable(c.newColumnSorter(ordered))
+	}
+	if dirsFirst {
+		sort.Stable(directoriesFirst{c, ordered})
+	}
+	return c.pinMatches(ordered)
+}
+
+// emptyQueryMatches returns the lines that are displayed while the
+// query is empty. See EmptyQueryConfig
+func (c *Ctx) emptyQueryMatches() []Match {
+	lines := c.scopedLines()
+	if c.isEmptyQueryLimited() {
+		n := c.config.EmptyQuery.Lines
+		if n < 0 {
+			n = 0
+		}
+		if n < len(lines) {
+			lines = lines[:n]
+		}
+	}
+	return c.orderMatches(lines)
+}
+
+// isEmptyQueryLimited returns true if the query is empty, and the
+// buffer is too large to be displayed in its entirety
+func (c *Ctx) isEmptyQueryLimited() bool {
+	threshold := c.config.EmptyQuery.Threshold
+	return threshold > 0 && len(c.query) == 0 && len(c.bufferLines()) > threshold
+}
+
+type directoriesFirst struct {
+	*Ctx
+	matches []Match
+}
+
+func (d directoriesFirst) Len() int {
+	return len(d.matches)
+}
+
+func (d directoriesFirst) Swap(i, j int) {
+	d.matches[i], d.matches[j] = d.matches[j], d.matches[i]
+}
+
+func (d directoriesFirst) Less(i, j int) bool {
+	return d.IsDirectory(d.matches[i]) && !d.IsDirectory(d.matches[j])
+}
+
 func (c *Ctx) DrawMatches(m []Match) {
 	c.SendDraw(m)
 }
@@ -152,35 +523,73 @@ func (c *Ctx) Refresh() {
 
 func (c *Ctx) Buffer() []Match {
 	// Copy lines so it's safe to read it
-	lcopy := make([]Match, len(c.lines))
-	copy(lcopy, c.lines)
+	lines := c.scopedLines()
+	lcopy := make([]Match, len(lines))
+	copy(lcopy, lines)
 	return lcopy
 }
 
+// scopedLines returns the lines that the query is matched against. This
+// is the entire buffer, unless peco.ToggleViewportScope has limited it
+// to the lines around the line that the cursor was on
+func (c *Ctx) scopedLines() []Match {
+	lines := c.bufferLines()
+	if c.scopeCenter < 0 {
+		return lines
+	}
+
+	// The lines are in the order of the input, so we can look for
+	// the center by its index
+	pos := sort.Search(len(lines), func(i int) bool {
+		return lines[i].Index() >= c.scopeCenter
+	})
+	n := c.config.ViewportScopeLines
+	start, end := pos-n, pos+n+1
+	if start < 0 {
+		start = 0
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return lines[start:end]
+}
+
 func (c *Ctx) NewBufferReader(r io.ReadCloser) *BufferReader {
 	return &BufferReader{c, r, make(chan struct{})}
 }
 
 func (c *Ctx) NewView() *View {
-	return &View{c, nil}
+	return &View{c, nil, newLineCache(c.config.DescriptionSeparator), ""}
 }
 
 func (c *Ctx) NewFilter() *Filter {
-	return &Filter{c, make(chan string)}
+	return &Filter{Ctx: c, jobs: make(chan string)}
 }
 
 func (c *Ctx) NewInput() *Input {
 	// Create a new keymap object
-	k := NewKeymap(c.config.Keymap, c.config.Action)
+	k := NewKeymap(c.config.Keymap, c.config.Action, c.config.ActionAliases)
+	k.KeyNames = c.config.KeyNames
 	k.ApplyKeybinding()
-	return &Input{c, &sync.Mutex{}, nil, k, []string{}}
+	return &Input{c, &sync.Mutex{}, nil, k, []string{}, nil}
 }
 
 func (c *Ctx) SetQuery(q []rune) {
+	q, _ = c.truncateQuery(q)
 	c.query = q
 	c.caretPos = len(q)
 }
 
+// truncateQuery truncates `q` to MaxQueryLength characters. Returns
+// true if `q` was truncated
+func (c *Ctx) truncateQuery(q []rune) ([]rune, bool) {
+	max := c.config.MaxQueryLength
+	if max <= 0 || len(q) <= max {
+		return q, false
+	}
+	return q[:max], true
+}
+
 func (c *Ctx) Matcher() Matcher {
 	return c.Matchers[c.CurrentMatcher]
 }
@@ -193,6 +602,16 @@ func (c *Ctx) AddMatcher(m Matcher) error {
 	return nil
 }
 
+// switchMatcher makes the n-th matcher the current matcher, and
+// remembers the previous one for peco.ToggleLastMatcher
+func (c *Ctx) switchMatcher(n int) {
+	if n != c.CurrentMatcher {
+		c.previousMatcher = c.CurrentMatcher
+		c.CurrentMatcher = n
+		c.matcherSwitched = true
+	}
+}
+
 func (c *Ctx) SetCurrentMatcher(n string) bool {
 	for i, m := range c.Matchers {
 		if m.String() == n {
@@ -203,6 +622,17 @@ func (c *Ctx) SetCurrentMatcher(n string) bool {
 	return false
 }
 
+// matcherIndex returns the index of the matcher named `n` in
+// c.Matchers, or -1 if there is no such matcher
+func (c *Ctx) matcherIndex(n string) int {
+	for i, m := range c.Matchers {
+		if m.String() == n {
+			return i
+		}
+	}
+	return -1
+}
+
 func (c *Ctx) LoadCustomMatcher() error {
 	if len(c.config.CustomMatcher) == 0 {
 		return nil
@@ -217,6 +647,7 @@ func (c *Ctx) LoadCustomMatcher() error {
 }
 
 func (c *Ctx) ExitWith(i int) {
+	c.logger.logf(LogInfo, "exit", "status", i)
 	c.ExitStatus = i
 	c.Stop()
 }
@@ -255,3 +686,8 @@ func (s *SignalHandler) Loop() {
 func (c *Ctx) SetPrompt(p []rune) {
 	c.prompt = p
 }
+
+// SetInputJSON makes peco read the input as a JSON array of lines
+func (c *Ctx) SetInputJSON(b bool) {
+	c.inputJSON = b
+}
@@ -0,0 +1,67 @@
+package peco
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// lookupEncoding returns the encoding named `name`, which is one of the
+// names registered with IANA (e.g. "Shift_JIS", "EUC-JP", "latin1").
+// Returns nil for UTF-8, as there's nothing to convert
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("error: Unsupported encoding '%s'", name)
+	}
+	if enc == unicode.UTF8 {
+		return nil, nil
+	}
+	return enc, nil
+}
+
+// SetInputEncoding sets the encoding that the input is decoded from
+// (see InputEncoding)
+func (c *Ctx) SetInputEncoding(name string) error {
+	if _, err := lookupEncoding(name); err != nil {
+		return err
+	}
+	c.config.InputEncoding = name
+	return nil
+}
+
+// decodeInput returns a reader that converts `r` from InputEncoding
+// into UTF-8. Invalid byte sequences are replaced with U+FFFD
+func (c *Ctx) decodeInput(r io.Reader) io.Reader {
+	enc, _ := lookupEncoding(c.config.InputEncoding)
+	if enc == nil {
+		return r
+	}
+	return transform.NewReader(r, enc.NewDecoder())
+}
+
+// EncodeOutput converts `s` back into InputEncoding, if EncodeOutput is
+// set. Characters that the encoding can't represent are replaced
+func (c *Ctx) EncodeOutput(s string) string {
+	if !c.config.EncodeOutput {
+		return s
+	}
+
+	enc, _ := lookupEncoding(c.config.InputEncoding)
+	if enc == nil {
+		return s
+	}
+	out, err := encoding.ReplaceUnsupported(enc.NewEncoder()).String(s)
+	if err != nil {
+		return s
+	}
+	return out
+}
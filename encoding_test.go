@@ -0,0 +1,35 @@
+package peco
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestInputEncoding(t *testing.T) {
+	c := newTestCtx()
+	if err := c.SetInputEncoding("no-such-encoding"); err == nil {
+		t.Errorf("expected an unknown encoding to be rejected")
+	}
+	if err := c.SetInputEncoding("Shift_JIS"); err != nil {
+		t.Fatalf("Failed to set encoding: %s", err)
+	}
+
+	// "日本" in Shift_JIS, followed by an invalid byte sequence
+	sjis := []byte{0x93, 0xfa, 0x96, 0x7b, 0x0a, 0x81}
+	buf, err := ioutil.ReadAll(c.decodeInput(bytes.NewReader(sjis)))
+	if err != nil {
+		t.Fatalf("Failed to decode input: %s", err)
+	}
+	if string(buf) != "日本\n�" {
+		t.Errorf("expected input to be decoded as UTF-8, got %q", buf)
+	}
+
+	if out := c.EncodeOutput("日本"); out != "日本" {
+		t.Errorf("expected output to be UTF-8 unless EncodeOutput is set, got %q", out)
+	}
+	c.config.EncodeOutput = true
+	if out := c.EncodeOutput("日本"); out != string(sjis[:4]) {
+		t.Errorf("expected output to be encoded back to Shift_JIS, got %q", out)
+	}
+}
@@ -0,0 +1,53 @@
+package peco
+
+import (
+	"bytes"
+	"strings"
+)
+
+// These are the values that can be specified in ExportFormat.Target
+const (
+	// ExportToClipboard copies the exported lines to the clipboard
+	ExportToClipboard = "clipboard"
+	// ExportToStdout prints the exported lines, and exits
+	ExportToStdout = "stdout"
+)
+
+// ExportFormat describes how peco.ExportAs formats the lines
+type ExportFormat struct {
+	// Header and Footer are printed before and after the lines
+	Header string `json:"Header"`
+	Footer string `json:"Footer"`
+	// Line is the template for each line. "{}" is replaced by the
+	// line. By default, "{}\n"
+	Line string `json:"Line"`
+	// Target is where the result goes. See ExportToClipboard and
+	// ExportToStdout
+	Target string `json:"Target"`
+}
+
+// Format formats `lines` using the export format
+func (f ExportFormat) Format(lines []string) string {
+	tmpl := f.Line
+	if tmpl == "" {
+		tmpl = "{}\n"
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(f.Header)
+	for _, line := range lines {
+		buf.WriteString(strings.Replace(tmpl, "{}", line, -1))
+	}
+	buf.WriteString(f.Footer)
+	return buf.String()
+}
+
+// ExportedOutput returns the output created by peco.ExportAs, if peco
+// exited that way. When it returns true, it should be printed instead
+// of the result
+func (c *Ctx) ExportedOutput() (string, bool) {
+	if c.exported == nil {
+		return "", false
+	}
+	return *c.exported, true
+}
@@ -0,0 +1,25 @@
+package peco
+
+import "testing"
+
+func TestExportFormat(t *testing.T) {
+	f := ExportFormat{Header: "git add --", Line: " '{}'", Footer: "\n"}
+	if out := f.Format([]string{"foo.go", "bar.go"}); out != "git add -- 'foo.go' 'bar.go'\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	f = ExportFormat{Header: "cat <<'EOF'\n", Footer: "EOF\n"}
+	if out := f.Format([]string{"foo"}); out != "cat <<'EOF'\nfoo\nEOF\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestResolveActionArgument(t *testing.T) {
+	km := NewKeymap(nil, nil, nil)
+	if _, err := km.resolveActionName("peco.ExportAs(git)", 0); err != nil {
+		t.Errorf("Failed to resolve action with argument: %s", err)
+	}
+	if _, err := km.resolveActionName("peco.SelectNext(git)", 0); err == nil {
+		t.Errorf("expected actions that don't take arguments to fail")
+	}
+}
@@ -1,24 +1,138 @@
 package peco
 
+import (
+	"os"
+	"sync"
+	"time"
+)
+
 // Filter is responsible for the actual "grep" part of peco
 type Filter struct {
 	*Ctx
 	jobs chan string
+	// version is incremented for each query, so that the results of
+	// queries that have been superseded can be discarded
+	mutex   sync.Mutex
+	version uint64
 }
 
 // Work is the actual work horse that that does the matching
-// in a goroutine of its own. It wraps Matcher.Match().
-func (f *Filter) Work(cancel chan struct{}, q HubReq) {
+// in a goroutine of its own. It wraps Matcher.Match(). `version`
+// is the version of the query returned by nextVersion
+func (f *Filter) Work(cancel chan struct{}, q HubReq, version uint64) {
 	defer q.Done()
 	query := q.DataString()
 	if query == "" {
-		f.DrawMatches(nil)
+		// The query was cleared, and whoever cleared it displays the
+		// lines. The version has been bumped all the same, so that the
+		// results of the previous queries are discarded
+		if f.isLatest(version) {
+			f.SendStatusMsg("")
+		}
+		return
+	}
+
+	// Queries are normally limited when they are entered, but make
+	// sure that we don't try to match against an absurdly long one
+	if q, truncated := f.truncateQuery([]rune(query)); truncated {
+		query = string(q)
+	}
+	// Comparisons with NumericColumns are applied before the matcher,
+	// which only sees the rest of the query
+	text, comparisons := f.splitComparisons(query)
+
+	// If the matcher can tell that the query is invalid, keep displaying
+	// the previous results instead of an empty screen
+	if qe, ok := f.Matcher().(interface {
+		QueryError(string) error
+	}); ok && text != "" {
+		if err := qe.QueryError(text); err != nil {
+			f.logger.logf(LogError, "query", "query", query, "error", err.Error())
+			if f.isLatest(version) {
+				f.SendStatusMsg(err.Error())
+			}
+			return
+		}
+	}
+
+	start := time.Now()
+	buf := f.Buffer()
+	if len(comparisons) > 0 {
+		buf = f.filterComparisons(buf, comparisons)
+	}
+	matches := buf
+	if text != "" {
+		matches = f.Matcher().Match(cancel, text, buf)
+	}
+	matches = f.orderMatches(matches)
+	f.logger.logf(LogInfo, "match", "query", query, "matcher", f.Matcher().String(), "lines", len(buf), "matches", len(matches), "duration", time.Since(start))
+	if !f.setMatches(version, matches) {
+		f.logger.logf(LogDebug, "discard", "query", query)
+		// A newer query came in while we were matching. Whatever we
+		// have now is stale (and may be incomplete, if we were
+		// cancelled), so don't display it
 		return
 	}
-	f.current = f.Matcher().Match(cancel, query, f.Buffer())
 	f.SendStatusMsg("")
-	f.selection.Clear()
 	f.DrawMatches(nil)
+	f.checkNoMatch(len(matches) == 0)
+}
+
+// checkNoMatch gives the NoMatchSignal when the query stops matching
+// anything. It isn't given again until the query matches something
+func (f *Filter) checkNoMatch(noMatch bool) {
+	d := time.Duration(f.config.FlashDuration) * time.Millisecond
+
+	// Filter has a mutex of its own, but these are read by the view
+	f.Ctx.mutex.Lock()
+	started := noMatch && !f.noMatch
+	f.noMatch = noMatch
+	if started && f.config.NoMatchSignal == NoMatchFlash {
+		f.promptFlashUntil = time.Now().Add(d)
+	}
+	f.Ctx.mutex.Unlock()
+	if !started {
+		return
+	}
+
+	switch f.config.NoMatchSignal {
+	case NoMatchBell:
+		os.Stderr.Write([]byte("\a"))
+	case NoMatchFlash:
+		f.DrawMatches(nil)
+		time.AfterFunc(d, func() {
+			f.DrawMatches(nil)
+		})
+	}
+}
+
+// nextVersion returns the version for a new query. Any results for
+// the previous versions are discarded from now on
+func (f *Filter) nextVersion() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.version++
+	return f.version
+}
+
+// isLatest returns true if `version` is the version of the last query
+func (f *Filter) isLatest(version uint64) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return version == f.version
+}
+
+// setMatches makes `matches` the current matches, unless they were
+// computed for an older version of the query. Returns false if the
+// matches were discarded
+func (f *Filter) setMatches(version uint64, matches []Match) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if version != f.version {
+		return false
+	}
+	f.current = matches
+	return true
 }
 
 // Loop keeps watching for incoming queries, and upon receiving
@@ -43,8 +157,10 @@ func (f *Filter) Loop() {
 			}
 			previous = make(chan struct{}, 1)
 
-			f.SendStatusMsg("Running query...")
-			go f.Work(previous, q)
+			if q.DataString() != "" {
+				f.SendStatusMsg("Running query...")
+			}
+			go f.Work(previous, q, f.nextVersion())
 		}
 	}
 }
@@ -0,0 +1,113 @@
+package peco
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFilterDiscardsStaleMatches(t *testing.T) {
+	c := newTestCtx()
+	c.lines = []Match{
+		NewNoMatch("foo", false, 0),
+		NewNoMatch("bar", false, 1),
+	}
+	f := c.NewFilter()
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	go func() {
+		for range c.StatusMsgCh() {
+		}
+	}()
+
+	// execQuery sends the query, and takes it as the filter would
+	execQuery := func(q string) (HubReq, uint64) {
+		c.SetQuery([]rune(q))
+		c.ExecQuery()
+		return <-c.QueryCh(), f.nextVersion()
+	}
+
+	// The match pass for the first query completes after the one
+	// for the second query
+	q1, v1 := execQuery("foo")
+	q2, v2 := execQuery("bar")
+	f.Work(make(chan struct{}, 1), q2, v2)
+	f.Work(make(chan struct{}, 1), q1, v1)
+	if len(f.current) != 1 || f.current[0].Line() != "bar" {
+		t.Errorf("expected matches for the latest query to be displayed, got %v", f.current)
+	}
+
+	// Clearing the query discards the results of the query before it
+	q3, v3 := execQuery("foo")
+	q4, v4 := execQuery("")
+	c.current = nil
+	f.Work(make(chan struct{}, 1), q4, v4)
+	f.Work(make(chan struct{}, 1), q3, v3)
+	if f.current != nil {
+		t.Errorf("expected matches for a stale query to be discarded once the query is cleared, got %v", f.current)
+	}
+}
+
+func TestNoMatchSignalAfterClearingQuery(t *testing.T) {
+	c := newTestCtx()
+	c.config.NoMatchSignal = NoMatchFlash
+	c.config.FlashDuration = 1000
+	c.lines = []Match{NewNoMatch("foo", false, 0)}
+	f := c.NewFilter()
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	go func() {
+		for range c.StatusMsgCh() {
+		}
+	}()
+
+	typeQuery := func(q string) {
+		c.SetQuery([]rune(q))
+		c.ExecQuery()
+		f.Work(make(chan struct{}, 1), <-c.QueryCh(), f.nextVersion())
+	}
+
+	for _, q := range []string{"xxx", "yyy"} {
+		c.promptFlashUntil = time.Time{}
+		typeQuery(q)
+		if !time.Now().Before(c.promptFlashUntil) {
+			t.Errorf("expected the prompt to flash when '%s' matches nothing", q)
+		}
+
+		// adding to a query that matches nothing doesn't flash again
+		c.promptFlashUntil = time.Time{}
+		typeQuery(q + "z")
+		if !c.promptFlashUntil.IsZero() {
+			t.Errorf("expected the prompt to not flash again for '%sz'", q)
+		}
+
+		typeQuery("")
+	}
+}
+
+func TestViewportScope(t *testing.T) {
+	c := newTestCtx()
+	c.config.ViewportScopeLines = 2
+	for n := 0; n < 10; n++ {
+		c.lines = append(c.lines, NewNoMatch(fmt.Sprintf("line %d", n), false, n))
+	}
+
+	if len(c.Buffer()) != 10 {
+		t.Errorf("expected the entire buffer to be matched, got %d lines", len(c.Buffer()))
+	}
+
+	c.scopeCenter = 5
+	buf := c.Buffer()
+	if len(buf) != 5 || buf[0].Index() != 3 || buf[4].Index() != 7 {
+		t.Errorf("expected lines 3 to 7 to be matched, got %v", buf)
+	}
+
+	c.scopeCenter = 1
+	if buf := c.Buffer(); len(buf) != 4 || buf[0].Index() != 0 {
+		t.Errorf("expected lines 0 to 3 to be matched, got %v", buf)
+	}
+}
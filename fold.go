@@ -0,0 +1,111 @@
+package peco
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// specialFolds lists runes whose full case folding is longer than a
+// single rune. unicode.ToLower does not handle these
+var specialFolds = map[rune]string{
+	'ß': "ss",
+	'ẞ': "ss",
+	'ﬀ': "ff",
+	'ﬁ': "fi",
+	'ﬂ': "fl",
+	'ﬃ': "ffi",
+	'ﬄ': "ffl",
+	'ﬅ': "st",
+	'ﬆ': "st",
+}
+
+// foldedString is a string that has been case folded, along with the
+// information required to map byte offsets in the folded string back
+// to the original string. Folding may change the length of the string
+// (e.g. "ß" becomes "ss"), so the offsets can not be used as is
+type foldedString struct {
+	folded string
+	// starts[i] and ends[i] are the offsets of the original rune that
+	// produced the i-th byte in folded
+	starts []int
+	ends   []int
+}
+
+// foldString applies full case folding to `s`
+func foldString(s string) *foldedString {
+	return transformString(s, foldRune)
+}
+
+// normalizePath applies full case folding to `s`, and also converts
+// backslashes to slashes, so that paths can be compared regardless of
+// the path separator that they use
+func normalizePath(s string) *foldedString {
+	return transformString(s, func(r rune) string {
+		if r == '\\' {
+			return "/"
+		}
+		return foldRune(r)
+	})
+}
+
+// foldRune returns the full case folding of `r`
+func foldRune(r rune) string {
+	if x, ok := specialFolds[r]; ok {
+		return x
+	}
+	return string(unicode.ToLower(r))
+}
+
+// transformString replaces each rune in `s` with the result of `fn`,
+// keeping track of the offsets of the original runes
+func transformString(s string, fn func(rune) string) *foldedString {
+	f := &foldedString{
+		starts: make([]int, 0, len(s)),
+		ends:   make([]int, 0, len(s)),
+	}
+
+	buf := make([]byte, 0, len(s))
+	for pos := 0; pos < len(s); {
+		r, w := utf8.DecodeRuneInString(s[pos:])
+
+		var folded string
+		if r == utf8.RuneError && w <= 1 {
+			// keep invalid bytes as is
+			folded = s[pos : pos+w]
+		} else {
+			folded = fn(r)
+		}
+
+		for i := 0; i < len(folded); i++ {
+			f.starts = append(f.starts, pos)
+			f.ends = append(f.ends, pos+w)
+		}
+		buf = append(buf, folded...)
+		pos += w
+	}
+	f.folded = string(buf)
+	return f
+}
+
+// originalRange maps the range [start, end) in the folded string to the
+// corresponding range in the original string. If the range starts or
+// ends in the middle of a rune that was expanded by folding, the entire
+// original rune is included
+func (f *foldedString) originalRange(start, end int) (int, int) {
+	if start >= len(f.starts) || end <= start {
+		n := len(f.starts)
+		if n == 0 {
+			return 0, 0
+		}
+		return f.ends[n-1], f.ends[n-1]
+	}
+	return f.starts[start], f.ends[end-1]
+}
+
+// mapIndices rewrites the [start, end, ...] match indices computed
+// against the folded string so that they point to the original string
+func (f *foldedString) mapIndices(matches [][]int) {
+	for _, m := range matches {
+		m[0], m[1] = f.originalRange(m[0], m[1])
+	}
+}
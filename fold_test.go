@@ -0,0 +1,82 @@
+package peco
+
+import "testing"
+
+func TestFoldString(t *testing.T) {
+	f := foldString("Straße")
+	if f.folded != "strasse" {
+		t.Fatalf("expected 'strasse', got '%s'", f.folded)
+	}
+
+	// "ß" is 2 bytes in the original, but 2 characters ("ss") when folded
+	tests := []struct {
+		start, end int
+		ostart     int
+		oend       int
+	}{
+		{0, 7, 0, 7}, // entire string
+		{4, 6, 4, 6}, // "ss" => "ß"
+		{4, 5, 4, 6}, // first "s" => "ß"
+		{5, 7, 4, 7}, // "se" => "ße"
+		{6, 7, 6, 7}, // "e" => "e"
+	}
+	for _, test := range tests {
+		s, e := f.originalRange(test.start, test.end)
+		if s != test.ostart || e != test.oend {
+			t.Errorf("expected [%d, %d) to map to [%d, %d), got [%d, %d)", test.start, test.end, test.ostart, test.oend, s, e)
+		}
+	}
+}
+
+func TestFullCaseFoldingMatch(t *testing.T) {
+	m := NewIgnoreCaseMatcher(false)
+	m.SetFullCaseFolding(true)
+
+	line := "Die Straße ist lang"
+	results := m.Match(make(chan struct{}), "STRASSE", []Match{NewNoMatch(line, false, 0)})
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+
+	indices := results[0].Indices()
+	if len(indices) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(indices))
+	}
+	if got := line[indices[0][0]:indices[0][1]]; got != "Straße" {
+		t.Errorf("expected the highlight to cover 'Straße', got '%s'", got)
+	}
+
+	results = m.Match(make(chan struct{}), "ss ist", []Match{NewNoMatch(line, false, 0)})
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	indices = results[0].Indices()
+	if got := line[indices[0][0]:indices[0][1]]; got != "ß" {
+		t.Errorf("expected the highlight to cover 'ß', got '%s'", got)
+	}
+}
+
+func TestPathNormalizationMatch(t *testing.T) {
+	m := NewIgnoreCaseMatcher(false)
+	m.SetPathNormalization(true)
+
+	lines := []Match{
+		NewNoMatch(`C:\Users\Foo\Documents`, false, 0),
+		NewNoMatch("/home/foo/documents", false, 1),
+		NewNoMatch("/home/bar/documents", false, 2),
+	}
+	for _, query := range []string{"foo/doc", `FOO\Doc`} {
+		results := m.Match(make(chan struct{}), query, lines)
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results for '%s', got %d", query, len(results))
+		}
+
+		for i, expected := range []string{`Foo\Doc`, "foo/doc"} {
+			line := results[i].Line()
+			indices := results[i].Indices()
+			if got := line[indices[0][0]:indices[0][1]]; got != expected {
+				t.Errorf("expected the highlight to cover '%s', got '%s'", expected, got)
+			}
+		}
+	}
+}
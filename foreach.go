@@ -0,0 +1,134 @@
+package peco
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// forEachResult is the result of running ForEachCommand for a line
+type forEachResult struct {
+	line   string
+	output string // the first line of the output
+	err    error
+}
+
+// StartForEach runs ForEachCommand for each of `lines` in the
+// background, and displays the results in a buffer of their own once
+// it's done. Returns false if it's already running
+func (c *Ctx) StartForEach(lines []string) bool {
+	c.mutex.Lock()
+	if c.forEachCancel != nil {
+		c.mutex.Unlock()
+		return false
+	}
+	cancel := make(chan struct{})
+	c.forEachCancel = cancel
+	c.mutex.Unlock()
+
+	go func() {
+		results, cancelled := c.runForEach(lines, cancel)
+
+		c.mutex.Lock()
+		if c.forEachCancel == cancel {
+			c.forEachCancel = nil
+		}
+		c.mutex.Unlock()
+
+		view, failed := forEachLines(results)
+		if len(view) > 0 {
+			// Enter or Esc closes the results. The buffer is pushed by
+			// the input loop
+			c.SendBuffer(func(i *Input) {
+				i.PushModalBuffer("ForEach", view, func(*Input, Match) {})
+				i.DrawMatches(nil)
+			})
+		}
+		msg := fmt.Sprintf("Ran '%s' for %d lines, %d failed", c.config.ForEachCommand, len(results), failed)
+		if cancelled {
+			msg = fmt.Sprintf("Cancelled after %d of %d lines, %d failed", len(results), len(lines), failed)
+		}
+		c.SendStatusMsg(msg)
+	}()
+	return true
+}
+
+// CancelForEach stops the commands started by StartForEach. The one
+// that is running is killed. Returns false if nothing is running
+func (c *Ctx) CancelForEach() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.forEachCancel == nil {
+		return false
+	}
+	close(c.forEachCancel)
+	c.forEachCancel = nil
+	return true
+}
+
+// runForEach runs ForEachCommand once for each of `lines`, with "{}"
+// replaced by the line, reporting the progress in the status message.
+// A command failing for one line doesn't stop the rest from running,
+// but closing `cancel` does. Returns true if it was cancelled, in which
+// case the line that was running isn't included in the results
+func (c *Ctx) runForEach(lines []string, cancel <-chan struct{}) ([]forEachResult, bool) {
+	results := make([]forEachResult, 0, len(lines))
+	for n, line := range lines {
+		select {
+		case <-cancel:
+			return results, true
+		default:
+		}
+		c.SendStatusMsg(fmt.Sprintf("Running %d/%d: %s", n+1, len(lines), line))
+
+		out := &bytes.Buffer{}
+		cmd := shellCommand(expandCommandTemplate(c.config.ForEachCommand, line))
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Start(); err != nil {
+			results = append(results, forEachResult{line, "", err})
+			continue
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-cancel:
+			cmd.Process.Kill()
+			<-done
+			return results, true
+		}
+
+		msg := strings.TrimSpace(out.String())
+		if i := strings.IndexByte(msg, '\n'); i > -1 {
+			msg = msg[:i]
+		}
+		results = append(results, forEachResult{line, msg, err})
+	}
+	return results, false
+}
+
+// forEachLines returns the lines that display `results` in the results
+// panel of peco.ForEachSelection, along with the number of failures
+func forEachLines(results []forEachResult) ([]Match, int) {
+	lines := make([]Match, len(results))
+	failed := 0
+	for n, r := range results {
+		status := "ok"
+		if r.err != nil {
+			status = fmt.Sprintf("failed (%s)", r.err)
+			failed++
+		}
+
+		l := fmt.Sprintf("[%s] %s", status, r.line)
+		if r.output != "" {
+			l += ": " + r.output
+		}
+		lines[n] = NewNoMatch(l, false, n)
+	}
+	return lines, failed
+}
@@ -0,0 +1,82 @@
+//go:build !windows
+// +build !windows
+
+package peco
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunForEach(t *testing.T) {
+	c := newTestCtx()
+	c.config.ForEachCommand = "test {} != bad && echo done {}"
+	go func() {
+		for range c.StatusMsgCh() {
+		}
+	}()
+
+	results, cancelled := c.runForEach([]string{"foo", "bad", "it's"}, make(chan struct{}))
+	if cancelled || len(results) != 3 {
+		t.Fatalf("expected the command to be run for all of the lines, got %d results", len(results))
+	}
+
+	lines, failed := forEachLines(results)
+	if failed != 1 {
+		t.Errorf("expected 1 failure, got %d", failed)
+	}
+	expected := []string{"[ok] foo: done foo", "[failed", "[ok] it's: done it's"}
+	for n, prefix := range expected {
+		if !strings.HasPrefix(lines[n].Line(), prefix) {
+			t.Errorf("expected result %d to start with '%s', got '%s'", n, prefix, lines[n].Line())
+		}
+	}
+}
+
+func TestCancelForEach(t *testing.T) {
+	c := newTestCtx()
+	c.config.ForEachCommand = "test {} = foo || sleep 10"
+	go func() {
+		for range c.StatusMsgCh() {
+		}
+	}()
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	i := c.NewInput()
+
+	if !c.StartForEach([]string{"foo", "bar", "baz"}) {
+		t.Fatalf("expected the commands to be started")
+	}
+	if c.StartForEach([]string{"foo"}) {
+		t.Errorf("expected the commands to not be started twice")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if !c.CancelForEach() {
+		t.Fatalf("expected the commands to be cancelled")
+	}
+	var r HubReq
+	select {
+	case r = <-c.BufferCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the results to be sent to the input loop")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the running command to be killed")
+	}
+	if c.CancelForEach() {
+		t.Errorf("expected nothing to cancel once the commands have stopped")
+	}
+
+	if !c.IsRootBuffer() {
+		t.Errorf("expected the results to not be pushed outside of the input loop")
+	}
+	r.DataInterface().(func(*Input))(i)
+	if s := bufferText(c.bufferLines()); s != "[ok] foo" {
+		t.Errorf("expected the results of the lines that were run to be displayed, got '%s'", s)
+	}
+}
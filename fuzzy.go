@@ -0,0 +1,139 @@
+package peco
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// FuzzyMatch is used as the key for the fuzzy matcher in the config file
+const FuzzyMatch = "Fuzzy"
+
+// FuzzyMatcher matches lines that contain all of the characters in the
+// query in the same order, but not necessarily next to each other.
+// Characters are compared ignoring case, and whitespace in the query
+// is ignored
+type FuzzyMatcher struct {
+	enableSep    bool
+	prefixLength int
+	descSep      string
+	wordStart    bool
+}
+
+// NewFuzzyMatcher creates a new FuzzyMatcher
+func NewFuzzyMatcher(enableSep bool) *FuzzyMatcher {
+	return &FuzzyMatcher{enableSep: enableSep}
+}
+
+func (m *FuzzyMatcher) String() string {
+	return FuzzyMatch
+}
+
+// Verify always returns nil
+func (m *FuzzyMatcher) Verify() error {
+	return nil
+}
+
+// SetPrefixLength limits matching to the first `n` characters of
+// each line. If `n` <= 0, the entire line is matched
+func (m *FuzzyMatcher) SetPrefixLength(n int) {
+	m.prefixLength = n
+}
+
+// SetDescriptionSeparator makes the matcher ignore the text after
+// `sep`, which is only displayed as the description of the line
+func (m *FuzzyMatcher) SetDescriptionSeparator(sep string) {
+	m.descSep = sep
+}
+
+// SetRequireWordStart makes the matcher only accept lines where the
+// first character of the query matches at the start of a word
+func (m *FuzzyMatcher) SetRequireWordStart(b bool) {
+	m.wordStart = b
+}
+
+// Match matches `q` against `buffer`
+func (m *FuzzyMatcher) Match(quit chan struct{}, q string, buffer []Match) []Match {
+	query := []rune{}
+	for _, r := range q {
+		if !unicode.IsSpace(r) {
+			query = append(query, unicode.ToLower(r))
+		}
+	}
+
+	results := []Match{}
+	if len(query) == 0 {
+		return results
+	}
+
+	for _, match := range buffer {
+		select {
+		case <-quit:
+			return results
+		default:
+		}
+
+		line, _ := splitDescription(match.Line(), m.descSep)
+		ms := m.matchLine(query, linePrefix(line, m.prefixLength))
+		if ms == nil {
+			continue
+		}
+		results = append(results, NewDidMatch(match.Buffer(), m.enableSep, match.Index(), ms))
+	}
+	return results
+}
+
+// matchLine returns the ranges of `line` that matched `query`, or nil
+// if it didn't match
+func (m *FuzzyMatcher) matchLine(query []rune, line string) [][]int {
+	for pos := 0; pos < len(line); {
+		r, w := utf8.DecodeRuneInString(line[pos:])
+		if unicode.ToLower(r) == query[0] && (!m.wordStart || isWordStart(line, pos)) {
+			if ms := fuzzyMatchFrom(query, line, pos); ms != nil {
+				return ms
+			}
+
+			// If the rest of the query can't be found after the first
+			// candidate, it can't be found after the later ones either
+			return nil
+		}
+		pos += w
+	}
+	return nil
+}
+
+// fuzzyMatchFrom matches `query` against `line`, with the first
+// character of the query matching at `start`
+func fuzzyMatchFrom(query []rune, line string, start int) [][]int {
+	_, w := utf8.DecodeRuneInString(line[start:])
+	ms := [][]int{{start, start + w}}
+
+	k := 1
+	for pos := start + w; pos < len(line) && k < len(query); {
+		r, w := utf8.DecodeRuneInString(line[pos:])
+		if unicode.ToLower(r) == query[k] {
+			if last := ms[len(ms)-1]; last[1] == pos {
+				last[1] = pos + w
+			} else {
+				ms = append(ms, []int{pos, pos + w})
+			}
+			k++
+		}
+		pos += w
+	}
+
+	if k < len(query) {
+		return nil
+	}
+	return ms
+}
+
+// isWordStart returns true if the character at `pos` is at the start
+// of a word, i.e. it's at the start of the line, or it follows a
+// character that is neither a letter nor a digit
+func isWordStart(line string, pos int) bool {
+	if pos == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(line[:pos])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
@@ -0,0 +1,35 @@
+package peco
+
+import "testing"
+
+func TestFuzzyMatch(t *testing.T) {
+	lines := []Match{
+		NewNoMatch("foo-abc", false, 0),
+		NewNoMatch("xabc", false, 1),
+		NewNoMatch("a_long_bar", false, 2),
+		NewNoMatch("cba", false, 3),
+	}
+
+	m := NewFuzzyMatcher(false)
+	results := m.Match(make(chan struct{}), "ab", lines)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	m.SetRequireWordStart(true)
+	results = m.Match(make(chan struct{}), "ab", lines)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for n, expected := range []int{0, 2} {
+		if results[n].Index() != expected {
+			t.Errorf("expected result %d to be line %d, got %d", n, expected, results[n].Index())
+		}
+	}
+
+	// "a" and "b" are highlighted separately, as they're not adjacent
+	indices := results[1].Indices()
+	if len(indices) != 2 || indices[0][0] != 0 || indices[1][0] != 7 {
+		t.Errorf("unexpected indices %v", indices)
+	}
+}
@@ -0,0 +1,89 @@
+package peco
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shellHistoryFile returns the history file of `shell` (e.g. the value
+// of $SHELL), and the name of the shell that determines its format.
+// $HISTFILE is used when it's set, as bash and zsh do
+func shellHistoryFile(shell, home string) (string, string) {
+	name := filepath.Base(shell)
+	histfile := os.Getenv("HISTFILE")
+	switch name {
+	case "zsh":
+		if histfile == "" {
+			histfile = filepath.Join(home, ".zsh_history")
+		}
+	case "fish":
+		histfile = filepath.Join(home, ".local", "share", "fish", "fish_history")
+	default:
+		name = "bash"
+		if histfile == "" {
+			histfile = filepath.Join(home, ".bash_history")
+		}
+	}
+	return histfile, name
+}
+
+// parseShellHistory extracts the commands from the history file of
+// `shell`, stripping timestamps. The commands are returned most recent
+// first, and only the most recent of the duplicates is kept
+func parseShellHistory(shell string, data string) []string {
+	var commands []string
+	for _, line := range strings.Split(data, "\n") {
+		switch shell {
+		case "zsh":
+			// Extended history is ": <timestamp>:<duration>;<command>"
+			if strings.HasPrefix(line, ": ") {
+				if i := strings.Index(line, ";"); i > -1 {
+					line = line[i+1:]
+				}
+			}
+		case "fish":
+			if !strings.HasPrefix(line, "- cmd: ") {
+				continue
+			}
+			line = strings.TrimPrefix(line, "- cmd: ")
+		case "bash":
+			// Timestamps are recorded as "#<timestamp>" when
+			// HISTTIMEFORMAT is set
+			if len(line) > 1 && line[0] == '#' && strings.Trim(line[1:], "0123456789") == "" {
+				continue
+			}
+		}
+		if strings.TrimSpace(line) != "" {
+			commands = append(commands, line)
+		}
+	}
+
+	seen := map[string]bool{}
+	history := make([]string, 0, len(commands))
+	for n := len(commands) - 1; n >= 0; n-- {
+		if seen[commands[n]] {
+			continue
+		}
+		seen[commands[n]] = true
+		history = append(history, commands[n])
+	}
+	return history
+}
+
+// loadShellHistory reads the history of the shell specified in $SHELL
+func (c *Ctx) loadShellHistory() ([]Match, error) {
+	file, shell := shellHistoryFile(os.Getenv("SHELL"), os.Getenv("HOME"))
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	history := parseShellHistory(shell, string(data))
+	lines := make([]Match, len(history))
+	for n, command := range history {
+		lines[n] = NewNoMatch(command, c.enableSep, n)
+	}
+	return lines, nil
+}
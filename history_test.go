@@ -0,0 +1,42 @@
+package peco
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseShellHistory(t *testing.T) {
+	tests := []struct {
+		shell    string
+		data     string
+		expected []string
+	}{
+		{
+			"bash",
+			"ls\n#1400000000\ncd /tmp\nls\n",
+			[]string{"ls", "cd /tmp"},
+		},
+		{
+			"zsh",
+			": 1400000000:0;ls\n: 1400000001:0;git status\n: 1400000002:0;ls -l\n",
+			[]string{"ls -l", "git status", "ls"},
+		},
+		{
+			"fish",
+			"- cmd: ls\n  when: 1400000000\n- cmd: make\n  when: 1400000001\n- cmd: ls\n  when: 1400000002\n",
+			[]string{"ls", "make"},
+		},
+	}
+	for _, test := range tests {
+		if history := parseShellHistory(test.shell, test.data); !reflect.DeepEqual(history, test.expected) {
+			t.Errorf("expected %s history to be %q, got %q", test.shell, test.expected, history)
+		}
+	}
+}
+
+func TestShellHistoryFile(t *testing.T) {
+	file, shell := shellHistoryFile("/usr/bin/fish", "/home/peco")
+	if file != "/home/peco/.local/share/fish/fish_history" || shell != "fish" {
+		t.Errorf("expected fish history, got %s (%s)", file, shell)
+	}
+}
@@ -17,6 +17,7 @@ type Hub struct {
 	statusMsgCh   chan HubReq
 	clearStatusCh chan HubReq
 	pagingCh      chan HubReq
+	bufferCh      chan HubReq
 }
 
 // HubReq is a wrapper around the actual requst value that needs
@@ -61,6 +62,7 @@ func NewHub() *Hub {
 		make(chan HubReq, 5), // statusMsgCh
 		make(chan HubReq, 5), // clearStatusCh
 		make(chan HubReq, 5), // pagingCh
+		make(chan HubReq, 5), // bufferCh
 	}
 }
 
@@ -151,6 +153,19 @@ func (h *Hub) SendPaging(x PagingRequest) {
 	send(h.PagingCh(), HubReq{x, nil}, h.isSync)
 }
 
+// BufferCh returns the channel to update the buffers from outside
+// the input loop
+func (h *Hub) BufferCh() chan HubReq {
+	return h.bufferCh
+}
+
+// SendBuffer sends `f` to be run by the input loop, which is the only
+// one that pushes and pops the buffers (e.g. for the output of a
+// command that ran in the background)
+func (h *Hub) SendBuffer(f func(*Input)) {
+	send(h.BufferCh(), HubReq{f, nil}, h.isSync)
+}
+
 // Stop closes the LoopCh so that peco shutsdown
 func (h *Hub) Stop() {
 	close(h.LoopCh())
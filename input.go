@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/nsf/termbox-go"
+	"github.com/peco/peco/keyseq"
 )
 
 // Input handles input events from termbox.
@@ -14,6 +15,14 @@ type Input struct {
 	mod    *time.Timer
 	keymap Keymap
 	currentKeySeq []string
+	// capture is set while peco.CaptureKey is waiting for a key
+	capture *keyCapture
+}
+
+// keyCapture collects the events that a key arrives as
+type keyCapture struct {
+	events []termbox.Event
+	timer  *time.Timer
 }
 
 // Loop watches for incoming events from termbox, and pass them
@@ -43,6 +52,9 @@ func (i *Input) Loop() {
 			return
 		case ev := <-evCh:
 			i.handleInputEvent(ev)
+		case r := <-i.BufferCh():
+			r.DataInterface().(func(*Input))(i)
+			r.Done()
 		}
 	}
 }
@@ -87,8 +99,49 @@ func (i *Input) handleInputEvent(ev termbox.Event) {
 }
 
 func (i *Input) handleKeyEvent(ev termbox.Event) {
+	if i.logger.enabled(LogDebug) {
+		if s, err := keyseq.EventToString(ev); err == nil {
+			i.logger.logf(LogDebug, "key", "key", s)
+		}
+	}
+	if i.captureKey(ev) {
+		return
+	}
 	if h := i.keymap.Handler(ev); h != nil {
 		h.Execute(i, ev)
 		return
 	}
 }
+
+// StartKeyCapture makes the next key be displayed instead of handled
+// (see peco.CaptureKey)
+func (i *Input) StartKeyCapture() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.capture = &keyCapture{}
+}
+
+// captureKey records `ev` if a key is being captured. Returns false if
+// it isn't, in which case `ev` needs to be handled as usual
+func (i *Input) captureKey(ev termbox.Event) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	c := i.capture
+	if c == nil {
+		return false
+	}
+	c.events = append(c.events, ev)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	// A key that termbox doesn't know arrives as several events in
+	// quick succession, so wait until they stop coming
+	c.timer = time.AfterFunc(100*time.Millisecond, func() {
+		i.mutex.Lock()
+		i.capture = nil
+		i.mutex.Unlock()
+		i.SendStatusMsg("Key: " + describeKeyEvents(c.events))
+	})
+	return true
+}
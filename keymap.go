@@ -1,6 +1,7 @@
 package peco
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -14,12 +15,16 @@ import (
 type Keymap struct {
 	Config map[string]string
 	Action map[string][]string // custom actions
+	Alias  map[string]string   // alternative names for actions
 	Keyseq *keyseq.Keyseq
+	// KeyNames maps the raw sequences that the terminal sends for
+	// keys that termbox doesn't know to names used in Config
+	KeyNames map[string]string
 }
 
 // NewKeymap creates a new Keymap struct
-func NewKeymap(config map[string]string, actions map[string][]string) Keymap {
-	return Keymap{config, actions, keyseq.New()}
+func NewKeymap(config map[string]string, actions map[string][]string, aliases map[string]string) Keymap {
+	return Keymap{config, actions, aliases, keyseq.New(), nil}
 
 }
 
@@ -79,12 +84,26 @@ func (km Keymap) resolveActionName(name string, depth int) (Action, error) {
 		return nil, fmt.Errorf("error: Could not resolve %s: deep recursion", name)
 	}
 
+	name, err := km.resolveAlias(name)
+	if err != nil {
+		return nil, err
+	}
+
 	// Can it be resolved via regular nameToActions ?
 	v, ok := nameToActions[name]
 	if ok {
 		return v, nil
 	}
 
+	// Is it an action with an argument, e.g. peco.ExportAs(git) ?
+	if base, arg, ok := splitActionArgument(name); ok {
+		if a, ok := nameToParamActions[base]; ok {
+			v = a.Bind(arg)
+			nameToActions[name] = v
+			return v, nil
+		}
+	}
+
 	// Can it be resolved via combined actions?
 	l, ok := km.Action[name]
 	if ok {
@@ -104,6 +123,62 @@ func (km Keymap) resolveActionName(name string, depth int) (Action, error) {
 	return nil, fmt.Errorf("error: Could not resolve %s: no such action", name)
 }
 
+// splitActionArgument splits the action name "name(arg)" into the
+// name and the argument. Returns false if there's no argument
+func splitActionArgument(name string) (string, string, bool) {
+	start := strings.IndexByte(name, '(')
+	if start < 1 || !strings.HasSuffix(name, ")") {
+		return "", "", false
+	}
+	return name[:start], name[start+1 : len(name)-1], true
+}
+
+// boundParamActions returns the actions with an argument that are
+// bound to keys or used by custom actions, e.g. peco.ExportAs(git)
+func (km Keymap) boundParamActions() []string {
+	names := []string{}
+	check := func(name string) {
+		name, err := km.resolveAlias(name)
+		if err != nil {
+			return
+		}
+		if base, _, ok := splitActionArgument(name); ok {
+			if _, ok := nameToParamActions[base]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+	for _, name := range km.Config {
+		check(name)
+	}
+	for _, l := range km.Action {
+		for _, name := range l {
+			check(name)
+		}
+	}
+	return names
+}
+
+// resolveAlias follows the aliases starting at `name`, and returns the
+// name of the action that it refers to
+func (km Keymap) resolveAlias(name string) (string, error) {
+	seen := []string{name}
+	for {
+		target, ok := km.Alias[name]
+		if !ok {
+			return name, nil
+		}
+
+		seen = append(seen, target)
+		for _, s := range seen[:len(seen)-1] {
+			if s == target {
+				return "", fmt.Errorf("error: Could not resolve %s: alias cycle (%s)", seen[0], strings.Join(seen, " -> "))
+			}
+		}
+		name = target
+	}
+}
+
 // ApplyKeybinding applies all of the custom key bindings on top of
 // the default key bindings
 func (km Keymap) ApplyKeybinding() {
@@ -133,18 +208,124 @@ func (km Keymap) ApplyKeybinding() {
 
 	// now compile using kb
 	for s, a := range kb {
-		list, err := keyseq.ToKeyList(s)
+		lists, err := km.toKeyLists(s)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Unknown key %s: %s", s, err)
 			continue
 		}
 
-		k.Add(list, a)
+		for _, list := range lists {
+			k.Add(list, a)
+		}
 	}
 
 	k.Compile()
 }
 
+// toKeyLists converts the key sequence `s` into the lists of keys to
+// bind. The names in KeyNames may be used as keys, and since each of
+// them may stand for more than one raw sequence, there may be more than
+// one list
+func (km Keymap) toKeyLists(s string) ([]keyseq.KeyList, error) {
+	lists := []keyseq.KeyList{{}}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+
+		alternatives := []keyseq.KeyList{}
+		for raw, name := range km.KeyNames {
+			if name == term {
+				alternatives = append(alternatives, rawKeyList(raw))
+			}
+		}
+		if len(alternatives) == 0 {
+			list, err := keyseq.ToKeyList(term)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, list)
+		}
+
+		combined := []keyseq.KeyList{}
+		for _, list := range lists {
+			for _, alt := range alternatives {
+				combined = append(combined, append(append(keyseq.KeyList{}, list...), alt...))
+			}
+		}
+		lists = combined
+	}
+	return lists, nil
+}
+
+// rawKeyList converts `raw`, the bytes that the terminal sends for a
+// key that termbox doesn't know, into the keys that they arrive as.
+// An Esc followed by a character arrives as Alt + the character (see
+// Input.handleInputEvent), and every other byte arrives on its own
+func rawKeyList(raw string) keyseq.KeyList {
+	list := keyseq.KeyList{}
+	runes := []rune(raw)
+	for n := 0; n < len(runes); n++ {
+		r := runes[n]
+		modifier := keyseq.ModNone
+		if r == 0x1b && n+1 < len(runes) {
+			modifier = keyseq.ModAlt
+			n++
+			r = runes[n]
+		}
+
+		switch {
+		case r <= ' ' || r == 0x7f:
+			list = append(list, keyseq.Key{Modifier: modifier, Key: termbox.Key(r), Ch: 0})
+		default:
+			list = append(list, keyseq.Key{Modifier: modifier, Key: 0, Ch: r})
+		}
+	}
+	return list
+}
+
+// eventToRaw returns the bytes that the terminal sent for `ev`. Returns
+// false for the keys that termbox recognizes, which have names
+func eventToRaw(ev termbox.Event) (string, bool) {
+	prefix := ""
+	if ev.Mod&termbox.ModAlt != 0 {
+		prefix = "\x1b"
+	}
+
+	switch {
+	case ev.Key == 0:
+		return prefix + string(ev.Ch), true
+	case ev.Key <= termbox.KeySpace || ev.Key == termbox.KeyBackspace2:
+		return prefix + string(rune(ev.Key)), true
+	}
+	return "", false
+}
+
+// describeKeyEvents describes the key that arrived as `events`. For
+// keys that termbox doesn't know, this is the raw sequence quoted as
+// it would be in KeyNames
+func describeKeyEvents(events []termbox.Event) string {
+	raw := ""
+	names := []string{}
+	for _, ev := range events {
+		s, ok := eventToRaw(ev)
+		if !ok {
+			raw = ""
+			break
+		}
+		raw += s
+	}
+	if raw != "" && (len(events) > 1 || raw[0] == 0x1b) {
+		buf, _ := json.Marshal(raw)
+		return string(buf)
+	}
+
+	for _, ev := range events {
+		if s, err := keyseq.EventToString(ev); err == nil {
+			names = append(names, s)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
 // TODO: this needs to be fixed.
 func (km Keymap) hasModifierMaps() bool {
 	return false
@@ -1,6 +1,10 @@
 package peco
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
 
 func TestSelection(t *testing.T) {
 	s := Selection([]int{})
@@ -22,3 +26,91 @@ func TestSelection(t *testing.T) {
 		t.Errorf("expected Len = 1, got %d", s.Len())
 	}
 }
+
+func TestResolveActionAlias(t *testing.T) {
+	km := NewKeymap(nil, nil, map[string]string{
+		"down": "peco.SelectNext",
+		"next": "down",
+		"a":    "b",
+		"b":    "c",
+		"c":    "a",
+	})
+
+	for _, name := range []string{"down", "next"} {
+		if _, err := km.resolveActionName(name, 0); err != nil {
+			t.Errorf("Failed to resolve %s: %s", name, err)
+		}
+	}
+
+	if _, err := km.resolveActionName("a", 0); err == nil {
+		t.Errorf("expected alias cycle to be detected")
+	}
+}
+
+func TestKeyNames(t *testing.T) {
+	km := NewKeymap(nil, nil, nil)
+	km.KeyNames = map[string]string{
+		"\x1b[15;2~": "S-F5",
+		"\x1b[28~":   "S-F5",
+	}
+
+	lists, err := km.toKeyLists("C-x,S-F5")
+	if err != nil {
+		t.Fatalf("Failed to convert key sequence: %s", err)
+	}
+	if len(lists) != 2 {
+		t.Fatalf("expected 2 key lists, got %d", len(lists))
+	}
+
+	for _, list := range lists {
+		if len(list) < 2 || list[0].Key != termbox.KeyCtrlX {
+			t.Errorf("expected the key list to start with C-x, got %#v", list)
+			continue
+		}
+
+		events := []termbox.Event{}
+		for _, k := range list[1:] {
+			events = append(events, termbox.Event{Mod: termbox.Modifier(k.Modifier), Key: k.Key, Ch: k.Ch})
+		}
+		if s := describeKeyEvents(events); s != `"\u001b[15;2~"` && s != `"\u001b[28~"` {
+			t.Errorf("expected the key list to be one of the raw sequences, got %s", s)
+		}
+	}
+}
+
+func TestKeyNamesHandler(t *testing.T) {
+	c := newTestCtx()
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+	go func() {
+		for range c.StatusMsgCh() {
+		}
+	}()
+	c.config.Keymap = map[string]string{"S-F5": "peco.BeginningOfLine"}
+	c.config.KeyNames = map[string]string{"\x1b[28~": "S-F5"}
+	i := c.NewInput()
+	i.SetQuery([]rune("foo"))
+
+	// "\x1b[28~" arrives as Alt+[ followed by the rest of the bytes
+	events := []termbox.Event{
+		{Type: termbox.EventKey, Mod: termbox.ModAlt, Ch: '['},
+		{Type: termbox.EventKey, Ch: '2'},
+		{Type: termbox.EventKey, Ch: '8'},
+		{Type: termbox.EventKey, Ch: '~'},
+	}
+	for _, ev := range events {
+		i.keymap.Handler(ev).Execute(i, ev)
+	}
+
+	if string(i.query) != "foo" {
+		t.Errorf("expected the keys not to be inserted into the query, got %q", string(i.query))
+	}
+	if i.caretPos != 0 {
+		t.Errorf("expected peco.BeginningOfLine to move the caret to 0, got %d", i.caretPos)
+	}
+	if len(i.currentKeySeq) != 0 {
+		t.Errorf("expected the key sequence to be cleared, got %v", i.currentKeySeq)
+	}
+}
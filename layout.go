@@ -0,0 +1,153 @@
+package peco
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// layoutCell is a single character in a line that has been laid out
+// for display
+type layoutCell struct {
+	x  int
+	ch rune
+	// term is the index of the query term that matched this character,
+	// or -1 if the character is not a part of a match
+	term int
+}
+
+// renderedLine holds a line that has been laid out for display. It does
+// not hold any styles, so that the same layout can be used regardless
+// of the line being selected or not
+type renderedLine struct {
+	cells []layoutCell
+	width int
+	// desc is the description of the line (see DescriptionSeparator),
+	// or nil if there is none
+	desc *renderedLine
+}
+
+// layoutLine computes the position of each character in the line,
+// and which query term it matched, if any. If `descSep` is not empty,
+// the text after it is laid out separately as the description
+func layoutLine(target Match, descSep string) *renderedLine {
+	line, desc := splitDescription(target.Line(), descSep)
+	r := layoutText(line, target.Indices())
+	if desc != "" {
+		// The description is only matched when peco.ToggleMatchDescription
+		// is on, in which case the matches need to be shifted
+		r.desc = layoutText(desc, shiftIndices(target.Indices(), len(line)+len(descSep)))
+	}
+	return r
+}
+
+// shiftIndices returns the matches that end after `offset`, moved
+// `offset` bytes to the left
+func shiftIndices(matches [][]int, offset int) [][]int {
+	var shifted [][]int
+	for _, m := range matches {
+		if m[1] <= offset {
+			continue
+		}
+		s := make([]int, len(m))
+		copy(s, m)
+		s[0] -= offset
+		s[1] -= offset
+		if s[0] < 0 {
+			s[0] = 0
+		}
+		shifted = append(shifted, s)
+	}
+	return shifted
+}
+
+func layoutText(line string, matches [][]int) *renderedLine {
+	r := &renderedLine{cells: make([]layoutCell, 0, len(line))}
+	mi := 0
+	for pos := 0; pos < len(line); {
+		c, w := utf8.DecodeRuneInString(line[pos:])
+		if c == utf8.RuneError && w <= 1 {
+			c = '?'
+			w = 1
+		}
+
+		for mi < len(matches) && matches[mi][1] <= pos {
+			mi++
+		}
+		term := -1
+		if mi < len(matches) && matches[mi][0] <= pos {
+			term = 0
+			if len(matches[mi]) > 2 {
+				term = matches[mi][2]
+			}
+		}
+
+		r.cells = append(r.cells, layoutCell{r.width, c, term})
+		if c == '\t' {
+			// Tabs are displayed as a single cell
+			r.width++
+		} else {
+			r.width += runewidth.RuneWidth(c)
+		}
+		pos += w
+	}
+	return r
+}
+
+// lineCache holds lines that have been laid out around the current
+// page. Lines are kept for up to Config.OverScan lines above and below
+// the page, so that moving the cursor or scrolling a few lines does not
+// require laying out everything from scratch.
+type lineCache struct {
+	lines   map[Match]*renderedLine
+	descSep string
+}
+
+func newLineCache(descSep string) *lineCache {
+	return &lineCache{map[Match]*renderedLine{}, descSep}
+}
+
+// Prepare makes sure that targets[start:end] are laid out, and
+// discards everything else
+func (lc *lineCache) Prepare(targets []Match, start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(targets) {
+		end = len(targets)
+	}
+
+	lines := make(map[Match]*renderedLine, end-start)
+	for i := start; i < end; i++ {
+		t := targets[i]
+		if r, ok := lc.lines[t]; ok {
+			lines[t] = r
+		} else {
+			lines[t] = layoutLine(t, lc.descSep)
+		}
+	}
+	lc.lines = lines
+}
+
+// Get returns the laid out line for `m`. If it has not been prepared,
+// it is laid out on the spot
+func (lc *lineCache) Get(m Match) *renderedLine {
+	if r, ok := lc.lines[m]; ok {
+		return r
+	}
+	return layoutLine(m, lc.descSep)
+}
+
+// splitDescription splits the line into the main text and the
+// description, which is the text after the first occurrence of `sep`.
+// If `sep` is empty or is not found, the description is empty
+func splitDescription(line, sep string) (string, string) {
+	if sep == "" {
+		return line, ""
+	}
+	if i := strings.Index(line, sep); i > -1 {
+		return line[:i], line[i+len(sep):]
+	}
+	return line, ""
+}
@@ -0,0 +1,104 @@
+package peco
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLayoutLine(t *testing.T) {
+	r := layoutLine(NewDidMatch("aあb", false, 0, [][]int{{1, 4, 1}}), "")
+	if r.width != 4 {
+		t.Errorf("expected width = 4, got %d", r.width)
+	}
+
+	expected := []layoutCell{
+		{0, 'a', -1},
+		{1, 'あ', 1},
+		{3, 'b', -1},
+	}
+	if len(r.cells) != len(expected) {
+		t.Fatalf("expected %d cells, got %d", len(expected), len(r.cells))
+	}
+	for i, cell := range expected {
+		if r.cells[i] != cell {
+			t.Errorf("expected cell %d to be %#v, got %#v", i, cell, r.cells[i])
+		}
+	}
+}
+
+func TestLayoutLineDescription(t *testing.T) {
+	r := layoutLine(NewNoMatch("ls\tlist files", false, 0), "\t")
+	if r.width != 2 {
+		t.Errorf("expected width = 2, got %d", r.width)
+	}
+	if r.desc == nil || r.desc.width != 10 {
+		t.Errorf("expected description to be laid out separately, got %#v", r.desc)
+	}
+}
+
+func TestMatchDescription(t *testing.T) {
+	c := newTestCtx()
+	c.config.DescriptionSeparator = "\t"
+	c.applyDescriptionSeparator()
+
+	lines := []Match{NewNoMatch("ls\tlist files", false, 0)}
+	m := c.Matcher()
+	if results := m.Match(make(chan struct{}), "files", lines); len(results) != 0 {
+		t.Errorf("expected the description to not be matched, got %d results", len(results))
+	}
+
+	c.matchDescription = true
+	c.applyDescriptionSeparator()
+	results := m.Match(make(chan struct{}), "files", lines)
+	if len(results) != 1 {
+		t.Fatalf("expected the description to be matched, got %d results", len(results))
+	}
+
+	r := layoutLine(results[0], "\t")
+	for n, cell := range r.desc.cells {
+		if matched := n >= 5; (cell.term >= 0) != matched {
+			t.Errorf("expected cell %d of the description to be matched = %t", n, matched)
+		}
+	}
+}
+
+func makeScrollTargets() []Match {
+	targets := make([]Match, 10000)
+	for i := range targets {
+		targets[i] = NewDidMatch(fmt.Sprintf("%d: the quick brown fox jumps over the lazy dog", i), false, i, [][]int{{10, 15}})
+	}
+	return targets
+}
+
+func benchmarkScroll(b *testing.B, overScan int) {
+	const perPage = 50
+	targets := makeScrollTargets()
+	cache := newLineCache("")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		offset := i % (len(targets) - perPage)
+		if overScan >= 0 {
+			cache.Prepare(targets, offset-overScan, offset+perPage+overScan)
+		}
+		for n := offset; n < offset+perPage; n++ {
+			if overScan >= 0 {
+				cache.Get(targets[n])
+			} else {
+				layoutLine(targets[n], "")
+			}
+		}
+	}
+}
+
+func BenchmarkScrollNoCache(b *testing.B) {
+	benchmarkScroll(b, -1)
+}
+
+func BenchmarkScrollOverScan0(b *testing.B) {
+	benchmarkScroll(b, 0)
+}
+
+func BenchmarkScrollOverScan5(b *testing.B) {
+	benchmarkScroll(b, 5)
+}
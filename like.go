@@ -0,0 +1,136 @@
+package peco
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+)
+
+// LikeMatch is used as the key for the LIKE matcher in the config file
+const LikeMatch = "Like"
+
+// LikeMatcher matches lines against the query as an SQL LIKE pattern,
+// i.e. "%" matches any number of characters, and "_" matches any single
+// character. The pattern must match the entire line. "\%", "\_" and
+// "\\" match "%", "_" and "\" respectively
+type LikeMatcher struct {
+	enableSep    bool
+	prefixLength int
+	descSep      string
+	ignoreCase   bool
+}
+
+// NewLikeMatcher creates a new LikeMatcher
+func NewLikeMatcher(enableSep bool) *LikeMatcher {
+	return &LikeMatcher{enableSep: enableSep}
+}
+
+func (m *LikeMatcher) String() string {
+	return LikeMatch
+}
+
+// Verify always returns nil
+func (m *LikeMatcher) Verify() error {
+	return nil
+}
+
+// SetPrefixLength limits matching to the first `n` characters of
+// each line. If `n` <= 0, the entire line is matched
+func (m *LikeMatcher) SetPrefixLength(n int) {
+	m.prefixLength = n
+}
+
+// SetDescriptionSeparator makes the matcher ignore the text after
+// `sep`, which is only displayed as the description of the line
+func (m *LikeMatcher) SetDescriptionSeparator(sep string) {
+	m.descSep = sep
+}
+
+// SetIgnoreCase makes the matcher compare characters ignoring case
+func (m *LikeMatcher) SetIgnoreCase(b bool) {
+	m.ignoreCase = b
+}
+
+var errLikeTrailingEscape = errors.New("error: LIKE pattern ends with an escape character")
+
+// likeToRegexp translates the LIKE pattern `q` into a regular expression.
+// Each run of literal characters is captured, so that it can be highlighted
+func likeToRegexp(q string, ignoreCase bool) (*regexp.Regexp, error) {
+	var buf, literal bytes.Buffer
+	if ignoreCase {
+		buf.WriteString("(?i)")
+	}
+	buf.WriteString("^")
+
+	flush := func() {
+		if literal.Len() > 0 {
+			buf.WriteString("(" + regexp.QuoteMeta(literal.String()) + ")")
+			literal.Reset()
+		}
+	}
+
+	escaped := false
+	for _, r := range q {
+		switch {
+		case escaped:
+			literal.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			flush()
+			buf.WriteString(".*")
+		case r == '_':
+			flush()
+			buf.WriteString(".")
+		default:
+			literal.WriteRune(r)
+		}
+	}
+	if escaped {
+		return nil, errLikeTrailingEscape
+	}
+	flush()
+	buf.WriteString("$")
+
+	return regexp.Compile(buf.String())
+}
+
+// QueryError returns the reason why `q` can't be used as a LIKE pattern,
+// or nil if it can
+func (m *LikeMatcher) QueryError(q string) error {
+	_, err := likeToRegexp(q, m.ignoreCase)
+	return err
+}
+
+// Match matches `q` against `buffer`
+func (m *LikeMatcher) Match(quit chan struct{}, q string, buffer []Match) []Match {
+	results := []Match{}
+	re, err := likeToRegexp(q, m.ignoreCase)
+	if err != nil {
+		return results
+	}
+
+	for _, match := range buffer {
+		select {
+		case <-quit:
+			return results
+		default:
+		}
+
+		line, _ := splitDescription(match.Line(), m.descSep)
+		groups := re.FindStringSubmatchIndex(linePrefix(line, m.prefixLength))
+		if groups == nil {
+			continue
+		}
+
+		ms := [][]int{}
+		for k := 2; k+1 < len(groups); k += 2 {
+			if groups[k] < groups[k+1] {
+				ms = append(ms, []int{groups[k], groups[k+1]})
+			}
+		}
+		results = append(results, NewDidMatch(match.Buffer(), m.enableSep, match.Index(), ms))
+	}
+	return results
+}
@@ -0,0 +1,54 @@
+package peco
+
+import "testing"
+
+func TestLikeMatch(t *testing.T) {
+	lines := []Match{
+		NewNoMatch("user_id", false, 0),
+		NewNoMatch("username", false, 1),
+		NewNoMatch("User-ID", false, 2),
+		NewNoMatch("100%", false, 3),
+	}
+
+	tests := []struct {
+		query      string
+		ignoreCase bool
+		expected   []int
+	}{
+		{"user%", false, []int{0, 1}},
+		{"user_id", false, []int{0}},
+		{"user_id", true, []int{0, 2}},
+		{`user\_id`, true, []int{0}},
+		{"%name", false, []int{1}},
+		{"name", false, nil}, // anchored to the entire line
+		{`%\%`, false, []int{3}},
+	}
+	for _, test := range tests {
+		m := NewLikeMatcher(false)
+		m.SetIgnoreCase(test.ignoreCase)
+		results := m.Match(make(chan struct{}), test.query, lines)
+		if len(results) != len(test.expected) {
+			t.Errorf("expected %d results for '%s', got %d", len(test.expected), test.query, len(results))
+			continue
+		}
+		for n, index := range test.expected {
+			if results[n].Index() != index {
+				t.Errorf("expected result %d for '%s' to be line %d, got %d", n, test.query, index, results[n].Index())
+			}
+		}
+	}
+
+	// Only the literal parts are highlighted
+	results := NewLikeMatcher(false).Match(make(chan struct{}), "u%_id", lines)
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	indices := results[0].Indices()
+	if len(indices) != 2 || indices[0][0] != 0 || indices[0][1] != 1 || indices[1][0] != 5 || indices[1][1] != 7 {
+		t.Errorf("expected 'u' and 'id' to be highlighted, got %v", indices)
+	}
+
+	if err := NewLikeMatcher(false).QueryError(`foo\`); err == nil {
+		t.Errorf("expected an error for a trailing escape character")
+	}
+}
@@ -0,0 +1,94 @@
+package peco
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel controls how much is written to the log file
+type LogLevel int
+
+// These are the log levels, from the least to the most verbose
+const (
+	LogError LogLevel = iota
+	LogInfo
+	LogDebug
+)
+
+var logLevelNames = map[string]LogLevel{
+	"error": LogError,
+	"info":  LogInfo,
+	"debug": LogDebug,
+}
+
+func (l LogLevel) String() string {
+	for name, level := range logLevelNames {
+		if level == l {
+			return strings.ToUpper(name)
+		}
+	}
+	return "UNKNOWN"
+}
+
+// eventLogger writes events to a file, one line per event, for
+// debugging. A nil eventLogger discards everything, so that logging
+// costs next to nothing unless --log is specified
+type eventLogger struct {
+	mutex sync.Mutex
+	out   io.Writer
+	level LogLevel
+}
+
+// enabled returns true if events of `level` are written, so that
+// callers can skip preparing expensive values otherwise
+func (l *eventLogger) enabled(level LogLevel) bool {
+	return l != nil && level <= l.level
+}
+
+// logf writes `event` along with the key/value pairs in `kv`, if
+// `level` is enabled. Values are quoted when they are strings
+func (l *eventLogger) logf(level LogLevel, event string, kv ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+
+	var buf []byte
+	buf = append(buf, time.Now().Format("2006-01-02T15:04:05.000Z07:00")...)
+	buf = append(buf, ' ')
+	buf = append(buf, level.String()...)
+	buf = append(buf, ' ')
+	buf = append(buf, event...)
+	for n := 0; n+1 < len(kv); n += 2 {
+		switch v := kv[n+1].(type) {
+		case string:
+			buf = append(buf, fmt.Sprintf(" %s=%q", kv[n], v)...)
+		default:
+			buf = append(buf, fmt.Sprintf(" %s=%v", kv[n], v)...)
+		}
+	}
+	buf = append(buf, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.out.Write(buf)
+}
+
+// SetLogFile makes peco write events to `file`, as verbose as specified
+// by `level` ("error", "info" or "debug")
+func (c *Ctx) SetLogFile(file, level string) error {
+	l, ok := logLevelNames[level]
+	if !ok {
+		return fmt.Errorf("error: Invalid log level '%s'", level)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.logger = &eventLogger{out: f, level: l}
+	return nil
+}
@@ -0,0 +1,26 @@
+package peco
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEventLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := &eventLogger{out: buf, level: LogInfo}
+	l.logf(LogInfo, "query", "query", "foo bar", "matches", 3)
+	l.logf(LogDebug, "key", "key", "C-n")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected debug events to be discarded, got %q", lines)
+	}
+	if !strings.HasSuffix(lines[0], ` INFO query query="foo bar" matches=3`) {
+		t.Errorf("unexpected log line '%s'", lines[0])
+	}
+
+	// Logging is a no-op when it's not enabled
+	var disabled *eventLogger
+	disabled.logf(LogError, "error")
+}
@@ -11,21 +11,24 @@ import (
 // Match defines the interface for matches. Note that to make drawing easier,
 // we have a DidMatch and NoMatch types instead of using []Match and []string.
 type Match interface {
-	Buffer() string // Raw buffer, may contain null
-	Line() string   // Line to be displayed
-	Output() string // Output string to be displayed after peco is done
-	Indices() [][]int
+	Buffer() string   // Raw buffer, may contain null
+	Line() string     // Line to be displayed
+	Output() string   // Output string to be displayed after peco is done
+	Indices() [][]int // [start, end] or [start, end, term] of each match
+	Index() int       // Position of the line in the original input (0 based)
 }
 
 type matchString struct {
 	buf    string
 	sepLoc int
+	index  int
 }
 
-func newMatchString(v string, enableSep bool) *matchString {
+func newMatchString(v string, enableSep bool, index int) *matchString {
 	m := &matchString{
 		v,
 		-1,
+		index,
 	}
 	if !enableSep {
 		return m
@@ -53,6 +56,10 @@ func (m matchString) Line() string {
 	return m.buf
 }
 
+func (m matchString) Index() int {
+	return m.index
+}
+
 func (m matchString) Output() string {
 	if i := m.sepLoc; i > -1 {
 		return m.buf[i+1:]
@@ -67,8 +74,8 @@ type NoMatch struct {
 }
 
 // NewNoMatch creates a NoMatch struct
-func NewNoMatch(v string, enableSep bool) *NoMatch {
-	return &NoMatch{newMatchString(v, enableSep)}
+func NewNoMatch(v string, enableSep bool, index int) *NoMatch {
+	return &NoMatch{newMatchString(v, enableSep, index)}
 }
 
 // Indices always returns nil
@@ -84,8 +91,8 @@ type DidMatch struct {
 }
 
 // NewDidMatch creates a new DidMatch struct
-func NewDidMatch(v string, enableSep bool, m [][]int) *DidMatch {
-	return &DidMatch{newMatchString(v, enableSep), m}
+func NewDidMatch(v string, enableSep bool, index int, m [][]int) *DidMatch {
+	return &DidMatch{newMatchString(v, enableSep, index), m}
 }
 
 // Indices returns the indices in the buffer that matched
@@ -93,6 +100,39 @@ func (d DidMatch) Indices() [][]int {
 	return d.matches
 }
 
+// matchScore returns how well `m` matched the query, from 0 to 100.
+// It's the percentage of the span from the first matched character to
+// the last one that was matched, so a line that contains the query as
+// is scores 100, and one where it's scattered (e.g. by the Fuzzy
+// matcher) scores lower. Returns false if nothing was matched
+func matchScore(m Match) (int, bool) {
+	indices := m.Indices()
+	if len(indices) == 0 {
+		return 0, false
+	}
+
+	start, end, matched := indices[0][0], indices[0][1], 0
+	for _, idx := range indices {
+		if idx[0] < start {
+			start = idx[0]
+		}
+		if idx[1] > end {
+			end = idx[1]
+		}
+		matched += idx[1] - idx[0]
+	}
+	if end <= start {
+		return 0, false
+	}
+
+	score := matched * 100 / (end - start)
+	if score > 100 {
+		// the terms overlap
+		score = 100
+	}
+	return score, true
+}
+
 // Matcher interface defines the API for things that want to
 // match against the buffer
 type Matcher interface {
@@ -109,7 +149,7 @@ type Matcher interface {
 
 	// This is fugly. We just added a method only for CustomMatcner.
 	// Must think about this again
-	Verify() error 
+	Verify() error
 }
 
 // These are used as keys in the config file
@@ -121,9 +161,13 @@ const (
 
 // RegexpMatcher is the most basic matcher
 type RegexpMatcher struct {
-	enableSep bool
-	flags     []string
-	quotemeta bool
+	enableSep    bool
+	flags        []string
+	quotemeta    bool
+	prefixLength int
+	fullFold     bool
+	descSep      string
+	normPaths    bool
 }
 
 // CaseSensitiveMatcher extends the RegxpMatcher, but always
@@ -168,6 +212,10 @@ func NewRegexpMatcher(enableSep bool) *RegexpMatcher {
 		enableSep,
 		[]string{},
 		false,
+		0,
+		false,
+		"",
+		false,
 	}
 }
 
@@ -176,6 +224,58 @@ func (m *RegexpMatcher) Verify() error {
 	return nil
 }
 
+// SetPrefixLength limits matching to the first `n` characters of
+// each line. If `n` <= 0, the entire line is matched
+func (m *RegexpMatcher) SetPrefixLength(n int) {
+	m.prefixLength = n
+}
+
+// SetDescriptionSeparator makes the matcher ignore the text after
+// `sep`, which is only displayed as the description of the line
+func (m *RegexpMatcher) SetDescriptionSeparator(sep string) {
+	m.descSep = sep
+}
+
+// SetFullCaseFolding makes the matcher apply full case folding to
+// both the query and the lines before matching, so that e.g. "ss"
+// matches "ß". The matches are still reported against the original line
+func (m *IgnoreCaseMatcher) SetFullCaseFolding(b bool) {
+	m.fullFold = b
+}
+
+// SetPathNormalization makes the matcher treat backslashes and slashes
+// as the same character, in addition to applying full case folding, so
+// that paths using either separator are matched by the same query. The
+// matches are still reported against the original line
+func (m *IgnoreCaseMatcher) SetPathNormalization(b bool) {
+	m.normPaths = b
+}
+
+// normalize returns `s` as it is compared against the query, when full
+// case folding or path normalization is enabled
+func (m *RegexpMatcher) normalize(s string) *foldedString {
+	if m.normPaths {
+		return normalizePath(s)
+	}
+	return foldString(s)
+}
+
+// linePrefix returns the first `n` characters of `line`
+func linePrefix(line string, n int) string {
+	if n <= 0 {
+		return line
+	}
+
+	i := 0
+	for pos := range line {
+		if i == n {
+			return line[:pos]
+		}
+		i++
+	}
+	return line
+}
+
 // NewCustomMatcher creates a new CustomMatcher
 func NewCustomMatcher(enableSep bool, name string, args []string) *CustomMatcher {
 	return &CustomMatcher{enableSep, name, args}
@@ -259,6 +359,9 @@ func (m byStart) Less(i, j int) bool {
 // is halted.
 func (m *RegexpMatcher) Match(quit chan struct{}, q string, buffer []Match) []Match {
 	results := []Match{}
+	if m.fullFold || m.normPaths {
+		q = m.normalize(q).folded
+	}
 	regexps, err := m.queryToRegexps(q)
 	if err != nil {
 		return results
@@ -279,11 +382,12 @@ func (m *RegexpMatcher) Match(quit chan struct{}, q string, buffer []Match) []Ma
 		// Iterate through the lines, and do the match.
 		// Upon success, send it through the channel
 		for _, match := range buffer {
-			ms := m.MatchAllRegexps(regexps, match.Line())
+			line, _ := splitDescription(match.Line(), m.descSep)
+			ms := m.matchLine(regexps, linePrefix(line, m.prefixLength))
 			if ms == nil {
 				continue
 			}
-			iter <- NewDidMatch(match.Buffer(), m.enableSep, ms)
+			iter <- NewDidMatch(match.Buffer(), m.enableSep, match.Index(), ms)
 		}
 		iter <- nil
 	}()
@@ -320,13 +424,29 @@ MATCH:
 	return results
 }
 
-// MatchAllRegexps matches all the regexps in `regexps` against line
+// matchLine matches all the regexps against line. If full case folding
+// or path normalization is enabled, matching is done against the
+// normalized line, and the matches are mapped back to the original line
+func (m *RegexpMatcher) matchLine(regexps []*regexp.Regexp, line string) [][]int {
+	if !m.fullFold && !m.normPaths {
+		return m.MatchAllRegexps(regexps, line)
+	}
+
+	f := m.normalize(line)
+	ms := m.MatchAllRegexps(regexps, f.folded)
+	f.mapIndices(ms)
+	return ms
+}
+
+// MatchAllRegexps matches all the regexps in `regexps` against line.
+// Each of the matches is returned as [start, end, term], where term is
+// the index of the regexp (i.e. query term) that matched
 func (m *RegexpMatcher) MatchAllRegexps(regexps []*regexp.Regexp, line string) [][]int {
 	matches := make([][]int, 0)
 
 	allMatched := true
 Match:
-	for _, re := range regexps {
+	for term, re := range regexps {
 		match := re.FindAllStringSubmatchIndex(line, -1)
 		if match == nil {
 			allMatched = false
@@ -344,7 +464,7 @@ Match:
 					continue Match
 				}
 			}
-			matches = append(matches, ma)
+			matches = append(matches, []int{start, end, term})
 		}
 	}
 
@@ -366,7 +486,7 @@ func (m *CustomMatcher) Match(quit chan struct{}, q string, buffer []Match) []Ma
 	results := []Match{}
 	if q == "" {
 		for _, match := range buffer {
-			results = append(results, NewDidMatch(match.Buffer(), m.enableSep, nil))
+			results = append(results, NewDidMatch(match.Buffer(), m.enableSep, match.Index(), nil))
 		}
 		return results
 	}
@@ -374,9 +494,14 @@ func (m *CustomMatcher) Match(quit chan struct{}, q string, buffer []Match) []Ma
 	// Receive elements from the goroutine performing the match
 	lines := []Match{}
 	matcherInput := ""
+	// The command only sees Line(), so each line it outputs is mapped
+	// back to the input it came from. Duplicate lines are queued so that
+	// each of them keeps its own index
+	inputs := map[string][]Match{}
 	for _, match := range buffer {
 		matcherInput += match.Line() + "\n"
 		lines = append(lines, match)
+		inputs[match.Line()] = append(inputs[match.Line()], match)
 	}
 	args := []string{}
 	for _, arg := range m.args {
@@ -404,7 +529,13 @@ func (m *CustomMatcher) Match(quit chan struct{}, q string, buffer []Match) []Ma
 		}
 		for _, line := range strings.Split(string(b), "\n") {
 			if len(line) > 0 {
-				iter <- NewDidMatch(line, m.enableSep, nil)
+				queue := inputs[line]
+				if len(queue) == 0 {
+					iter <- NewDidMatch(line, m.enableSep, -1, nil)
+					continue
+				}
+				inputs[line] = queue[1:]
+				iter <- NewDidMatch(queue[0].Buffer(), m.enableSep, queue[0].Index(), nil)
 			}
 		}
 		iter <- nil
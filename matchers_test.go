@@ -0,0 +1,108 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestMatchPrefixLength(t *testing.T) {
+	m := NewIgnoreCaseMatcher(false)
+	m.SetPrefixLength(5)
+
+	buffer := []Match{
+		NewNoMatch("ほげfoo bar", false, 0),
+		NewNoMatch("bar ほげfoo", false, 1),
+	}
+	results := m.Match(make(chan struct{}), "foo", buffer)
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+	if results[0].Line() != "ほげfoo bar" {
+		t.Errorf("expected the full line to be returned, got '%s'", results[0].Line())
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	tests := []struct {
+		indices  [][]int
+		expected int
+	}{
+		{[][]int{{3, 6}}, 100},
+		{[][]int{{0, 2}, {4, 6}}, 66},
+		{[][]int{{0, 1}, {9, 10}}, 20},
+		{[][]int{{0, 4}, {2, 6}}, 100},
+	}
+	for _, test := range tests {
+		score, ok := matchScore(NewDidMatch("0123456789", false, 0, test.indices))
+		if !ok || score != test.expected {
+			t.Errorf("expected %v to score %d, got %d", test.indices, test.expected, score)
+		}
+	}
+
+	if _, ok := matchScore(NewNoMatch("0123456789", false, 0)); ok {
+		t.Errorf("expected a line without matches to have no score")
+	}
+
+	c := newTestCtx()
+	c.config.ScoreStyles = []ScoreStyle{
+		{Threshold: 80, Style: Style{fg: termbox.ColorGreen}},
+		{Threshold: 0, Style: Style{fg: termbox.ColorBlue}},
+	}
+	if style := c.LineStyle(NewDidMatch("foo", false, 0, [][]int{{0, 3}})); style == nil || style.fg != termbox.ColorGreen {
+		t.Errorf("expected a good match to use the first style, got %#v", style)
+	}
+	if style := c.LineStyle(NewDidMatch("f-o-o", false, 0, [][]int{{0, 1}, {2, 3}, {4, 5}})); style == nil || style.fg != termbox.ColorBlue {
+		t.Errorf("expected a weak match to use the last style, got %#v", style)
+	}
+	if style := c.LineStyle(NewNoMatch("foo", false, 0)); style != nil {
+		t.Errorf("expected no style without a query, got %#v", style)
+	}
+}
+
+func TestMatchReportsTerm(t *testing.T) {
+	m := NewIgnoreCaseMatcher(false)
+	results := m.Match(make(chan struct{}), "bar foo", []Match{NewNoMatch("foo bar", false, 0)})
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+
+	expected := [][]int{{0, 3, 1}, {4, 7, 0}}
+	indices := results[0].Indices()
+	if len(indices) != len(expected) {
+		t.Fatalf("expected %d matches, got %d", len(expected), len(indices))
+	}
+	for i, e := range expected {
+		for j := range e {
+			if indices[i][j] != e[j] {
+				t.Errorf("expected match %d to be %v, got %v", i, e, indices[i])
+				break
+			}
+		}
+	}
+}
+
+func TestCustomMatcherIndices(t *testing.T) {
+	m := NewCustomMatcher(true, "grep", []string{"grep", "$QUERY"})
+	buffer := []Match{
+		NewNoMatch("foo\x00/path/1", true, 0),
+		NewNoMatch("bar\x00/path/2", true, 1),
+		NewNoMatch("foo\x00/path/3", true, 2),
+	}
+	results := m.Match(make(chan struct{}), "foo", buffer)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	for n, expected := range []struct {
+		index  int
+		output string
+	}{{0, "/path/1"}, {2, "/path/3"}} {
+		if results[n].Index() != expected.index || results[n].Output() != expected.output {
+			t.Errorf("expected result %d to be line %d with output '%s', got line %d with output '%s'",
+				n, expected.index, expected.output, results[n].Index(), results[n].Output())
+		}
+		if results[n].Line() != "foo" {
+			t.Errorf("expected result %d to be displayed as 'foo', got '%s'", n, results[n].Line())
+		}
+	}
+}
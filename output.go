@@ -0,0 +1,193 @@
+package peco
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// These are the values that can be specified in AcceptMode
+const (
+	// AcceptPrint prints the result to stdout (default)
+	AcceptPrint = "print"
+	// AcceptCopy copies the result to the clipboard, and prints nothing
+	AcceptCopy = "copy"
+)
+
+var _detectClipboard = DetectClipboard
+
+// SetAcceptMode specifies what is done with the result when peco exits.
+// See AcceptPrint and AcceptCopy
+func (c *Ctx) SetAcceptMode(s string) error {
+	switch s {
+	case AcceptPrint, AcceptCopy:
+		c.config.AcceptMode = s
+		return nil
+	}
+	return fmt.Errorf("error: Invalid AcceptMode '%s'", s)
+}
+
+// WriteOutput writes `out`, which is what peco exits with, to `w`. If
+// AcceptMode is AcceptCopy, it's copied to the clipboard instead
+func (c *Ctx) WriteOutput(w io.Writer, out string) error {
+	if c.config.AcceptMode != AcceptCopy {
+		_, err := io.WriteString(w, c.EncodeOutput(out))
+		return err
+	}
+
+	cb, err := _detectClipboard()
+	if err != nil {
+		return err
+	}
+	if err := cb.Copy(strings.TrimSuffix(out, "\n")); err != nil {
+		return fmt.Errorf("error: Failed to copy to %s: %s", cb, err)
+	}
+	return nil
+}
+
+// SetContinueOutput specifies where peco.AcceptAndContinue writes the
+// accepted lines. If it's never called, or AcceptMode is AcceptCopy,
+// they are kept until peco exits (see FinalOutput)
+func (c *Ctx) SetContinueOutput(w io.Writer) {
+	c.continueOutput = w
+}
+
+// AcceptAndContinue outputs `matches` without exiting. See
+// SetContinueOutput for where they go
+func (c *Ctx) AcceptAndContinue(matches []Match) error {
+	out := c.FormatOutput(matches)
+	if c.continueOutput == nil || c.config.AcceptMode == AcceptCopy {
+		c.continuedOutput += out
+		return nil
+	}
+	return c.WriteOutput(c.continueOutput, out)
+}
+
+// FinalOutput returns what is written (see WriteOutput) when peco
+// exits: the lines that peco.AcceptAndContinue kept until then,
+// followed by the output of peco.ExportAs or the result. They are
+// combined, so that they're copied at once with AcceptCopy. Returns
+// false if there's nothing to write
+func (c *Ctx) FinalOutput() (string, bool) {
+	out, ok := c.ExportedOutput()
+	if !ok {
+		out = c.FormatOutput(c.Result())
+		ok = out != ""
+	}
+	if c.continuedOutput != "" {
+		return c.continuedOutput + out, true
+	}
+	return out, ok
+}
+
+// FormatOutput creates the text that is printed when peco exits with
+// `matches` as the result. Each line is terminated by a newline, unless
+// OutputSeparator is set, in which case the lines are joined by the
+// separator, and only the last one is followed by a newline
+func (c *Ctx) FormatOutput(matches []Match) string {
+	sep := c.config.OutputSeparator
+	if c.enableSep {
+		// --null output is always newline terminated
+		sep = ""
+	}
+
+	buf := bytes.Buffer{}
+	for i, m := range matches {
+		if sep != "" && i > 0 {
+			buf.WriteString(sep)
+		}
+		buf.WriteString(c.formatOutputLine(strings.TrimSuffix(m.Output(), "\n")))
+		if sep == "" {
+			buf.WriteByte('\n')
+		}
+	}
+	if sep != "" && len(matches) > 0 {
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// outputPreviewReplacer makes the characters that are hard to see
+// visible in the output preview
+var outputPreviewReplacer = strings.NewReplacer(
+	"\n", `\n`,
+	"\t", `\t`,
+	"\x00", `\0`,
+)
+
+// previewOutput returns what would be printed if the current selection
+// were accepted, as a single line
+func (c *Ctx) previewOutput() string {
+	return outputPreviewReplacer.Replace(c.FormatOutput(c.TargetMatches()))
+}
+
+// These are the values that can be specified in OutputEscape
+const (
+	// OutputEscapeSuffix escapes occurrences of OutputSuffix in the
+	// line with a backslash, so that the line doesn't end early
+	// (default)
+	OutputEscapeSuffix = "suffix"
+	// OutputEscapeNone leaves the line as it is
+	OutputEscapeNone = "none"
+	// OutputEscapeDoubleQuote escapes the characters that are special
+	// inside double quotes in the shell, e.g. for `rm "` and `"`
+	OutputEscapeDoubleQuote = "double-quote"
+	// OutputEscapeSingleQuote escapes single quotes the way the shell
+	// needs them inside single quotes, e.g. for `rm '` and `'`
+	OutputEscapeSingleQuote = "single-quote"
+)
+
+// SetOutputEscape specifies how the wrapped lines are escaped. See
+// OutputEscapeSuffix and friends
+func (c *Ctx) SetOutputEscape(s string) error {
+	switch s {
+	case OutputEscapeSuffix, OutputEscapeNone, OutputEscapeDoubleQuote, OutputEscapeSingleQuote:
+		c.config.OutputEscape = s
+		return nil
+	}
+	return fmt.Errorf("error: Invalid OutputEscape '%s'", s)
+}
+
+// doubleQuoteReplacer escapes the characters that are special inside
+// double quotes in the shell
+var doubleQuoteReplacer = strings.NewReplacer(`\`, `\\`, `$`, `\$`, "`", "\\`", `"`, `\"`)
+
+// formatOutputLine wraps `line` with OutputPrefix and OutputSuffix,
+// escaping it according to OutputEscape. Lines are never wrapped with
+// --null, as they may contain anything
+func (c *Ctx) formatOutputLine(line string) string {
+	prefix := c.config.OutputPrefix
+	suffix := c.config.OutputSuffix
+	if (prefix == "" && suffix == "") || c.enableSep {
+		return line
+	}
+
+	switch c.config.OutputEscape {
+	case OutputEscapeNone:
+	case OutputEscapeDoubleQuote:
+		line = doubleQuoteReplacer.Replace(line)
+	case OutputEscapeSingleQuote:
+		line = strings.Replace(line, "'", `'\''`, -1)
+	default:
+		if suffix != "" {
+			line = strings.Replace(line, suffix, `\`+suffix, -1)
+		}
+	}
+	return prefix + line + suffix
+}
+
+// SetOutputPrefix sets the string to be prepended to each output line
+func (c *Ctx) SetOutputPrefix(s string) {
+	c.config.OutputPrefix = s
+}
+
+// SetOutputSuffix sets the string to be appended to each output line
+func (c *Ctx) SetOutputSuffix(s string) {
+	c.config.OutputSuffix = s
+}
+
+// SetOutputSeparator sets the string used to join the output lines
+func (c *Ctx) SetOutputSeparator(s string) {
+	c.config.OutputSeparator = s
+}
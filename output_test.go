@@ -0,0 +1,216 @@
+package peco
+
+import (
+	"bytes"
+	"testing"
+)
+
+type testCtxOptions struct {
+	enableNullSep bool
+	bufferSize    int
+	initialIndex  int
+}
+
+func (o testCtxOptions) EnableNullSep() bool {
+	return o.enableNullSep
+}
+
+func (o testCtxOptions) BufferSize() int {
+	return o.bufferSize
+}
+
+func (o testCtxOptions) InitialIndex() int {
+	return o.initialIndex
+}
+
+func newTestCtx() *Ctx {
+	return NewCtx(testCtxOptions{initialIndex: 1})
+}
+
+func TestFormatOutput(t *testing.T) {
+	c := newTestCtx()
+	matches := []Match{
+		NewNoMatch("foo", false, 0),
+		NewNoMatch(`a "quoted" file`, false, 1),
+	}
+
+	if out := c.FormatOutput(matches); out != "foo\na \"quoted\" file\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	c.SetOutputPrefix(`rm "`)
+	c.SetOutputSuffix(`"`)
+	expected := "rm \"foo\"\nrm \"a \\\"quoted\\\" file\"\n"
+	if out := c.FormatOutput(matches); out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestFormatOutputEscape(t *testing.T) {
+	tests := []struct {
+		escape, prefix, suffix, line, expected string
+	}{
+		{OutputEscapeSuffix, `rm "`, `"`, `a "quoted" file`, `rm "a \"quoted\" file"`},
+		{OutputEscapeSuffix, `f("`, `")`, `a")b`, `f("a\")b")`},
+		{OutputEscapeSuffix, `[`, `]`, `a]b`, `[a\]b]`},
+		{OutputEscapeSuffix, `<`, `>`, `$x`, `<$x>`},
+		{OutputEscapeSuffix, `[`, `]`, `a\b`, `[a\b]`},
+		{OutputEscapeNone, `[`, `]`, `a]b`, `[a]b]`},
+		{OutputEscapeDoubleQuote, `rm "`, `"`, `a "quoted" file`, `rm "a \"quoted\" file"`},
+		{OutputEscapeDoubleQuote, `rm "`, `"`, `$HOME/x`, `rm "\$HOME/x"`},
+		{OutputEscapeDoubleQuote, `rm "`, `"`, "`whoami`", "rm \"\\`whoami\\`\""},
+		{OutputEscapeDoubleQuote, `rm "`, `"`, `a\"b`, `rm "a\\\"b"`},
+		{OutputEscapeSingleQuote, `rm '`, `'`, `it's`, `rm 'it'\''s'`},
+		{OutputEscapeSingleQuote, `rm '`, `'`, `$HOME "x"`, `rm '$HOME "x"'`},
+	}
+	for _, test := range tests {
+		c := newTestCtx()
+		c.SetOutputPrefix(test.prefix)
+		c.SetOutputSuffix(test.suffix)
+		if err := c.SetOutputEscape(test.escape); err != nil {
+			t.Fatalf("failed to set OutputEscape: %s", err)
+		}
+		if out := c.formatOutputLine(test.line); out != test.expected {
+			t.Errorf("expected %q to be formatted as %q with %s, got %q", test.line, test.expected, test.escape, out)
+		}
+	}
+
+	c := newTestCtx()
+	if err := c.SetOutputEscape("shell"); err == nil {
+		t.Errorf("expected an error for an invalid OutputEscape")
+	}
+
+	// Lines are printed as they are with --null
+	c = NewCtx(testCtxOptions{enableNullSep: true, initialIndex: 1})
+	c.SetOutputPrefix(`rm "`)
+	c.SetOutputSuffix(`"`)
+	c.SetOutputEscape(OutputEscapeDoubleQuote)
+	if out := c.formatOutputLine(`$HOME`); out != `$HOME` {
+		t.Errorf("expected the line to not be wrapped with --null, got %q", out)
+	}
+}
+
+func TestFormatOutputSeparator(t *testing.T) {
+	c := newTestCtx()
+	c.SetOutputSeparator(" ")
+	matches := []Match{
+		NewNoMatch("foo", false, 0),
+		NewNoMatch("bar", false, 1),
+	}
+
+	if out := c.FormatOutput(matches); out != "foo bar\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if out := c.FormatOutput(nil); out != "" {
+		t.Errorf("expected empty output, got %q", out)
+	}
+}
+
+func TestPreviewOutput(t *testing.T) {
+	c := newTestCtx()
+	c.lines = []Match{
+		NewNoMatch("foo\tbar", false, 0),
+		NewNoMatch("baz", false, 1),
+	}
+	c.SelectLine(1)
+	c.SelectLine(2)
+	c.SetOutputPrefix("<")
+	c.SetOutputSuffix(">")
+
+	expected := `<foo\tbar>\n<baz>\n`
+	if p := c.previewOutput(); p != expected {
+		t.Errorf("expected %q, got %q", expected, p)
+	}
+}
+
+type testClipboard struct {
+	copied *string
+}
+
+func (c testClipboard) Copy(s string) error {
+	*c.copied = s
+	return nil
+}
+
+func (c testClipboard) String() string {
+	return "test"
+}
+
+func TestWriteOutput(t *testing.T) {
+	var copied string
+	_detectClipboard = func() (Clipboard, error) {
+		return testClipboard{&copied}, nil
+	}
+	defer func() { _detectClipboard = DetectClipboard }()
+
+	c := newTestCtx()
+	var buf bytes.Buffer
+	if err := c.WriteOutput(&buf, "foo\nbar\n"); err != nil {
+		t.Fatalf("Failed to write output: %s", err)
+	}
+	if buf.String() != "foo\nbar\n" || copied != "" {
+		t.Errorf("expected the output to be printed, got '%s'", buf.String())
+	}
+
+	if err := c.SetAcceptMode("paste"); err == nil {
+		t.Errorf("expected an invalid accept mode to be rejected")
+	}
+	if err := c.SetAcceptMode(AcceptCopy); err != nil {
+		t.Fatalf("Failed to set accept mode: %s", err)
+	}
+	buf.Reset()
+	if err := c.WriteOutput(&buf, "foo\nbar\n"); err != nil {
+		t.Fatalf("Failed to write output: %s", err)
+	}
+	if buf.Len() != 0 || copied != "foo\nbar" {
+		t.Errorf("expected the output to be copied instead of printed, got '%s' and '%s'", buf.String(), copied)
+	}
+}
+
+func TestAcceptAndContinue(t *testing.T) {
+	c := newTestCtx()
+	if err := c.AcceptAndContinue([]Match{NewNoMatch("foo", false, 0)}); err != nil {
+		t.Fatalf("failed to accept: %s", err)
+	}
+	c.AcceptAndContinue([]Match{NewNoMatch("bar", false, 1)})
+	if out, ok := c.FinalOutput(); !ok || out != "foo\nbar\n" {
+		t.Errorf("expected the lines to be kept until peco exits, got %q", out)
+	}
+	c.result = []Match{NewNoMatch("baz", false, 2)}
+	if out, _ := c.FinalOutput(); out != "foo\nbar\nbaz\n" {
+		t.Errorf("expected the kept lines to be followed by the result, got %q", out)
+	}
+
+	c = newTestCtx()
+	buf := &bytes.Buffer{}
+	c.SetContinueOutput(buf)
+	c.AcceptAndContinue([]Match{NewNoMatch("foo", false, 0)})
+	if buf.String() != "foo\n" {
+		t.Errorf("expected the lines to be written right away, got %q", buf.String())
+	}
+	if out, ok := c.FinalOutput(); ok {
+		t.Errorf("expected nothing to be kept, got %q", out)
+	}
+
+	// With AcceptMode "copy", the accepted lines and the result are
+	// copied together, rather than one replacing the other
+	var copied string
+	_detectClipboard = func() (Clipboard, error) {
+		return testClipboard{&copied}, nil
+	}
+	defer func() { _detectClipboard = DetectClipboard }()
+
+	c = newTestCtx()
+	c.SetAcceptMode(AcceptCopy)
+	c.SetContinueOutput(buf)
+	buf.Reset()
+	c.AcceptAndContinue([]Match{NewNoMatch("foo", false, 0)})
+	c.result = []Match{NewNoMatch("bar", false, 1)}
+	out, _ := c.FinalOutput()
+	if err := c.WriteOutput(buf, out); err != nil {
+		t.Fatalf("Failed to write output: %s", err)
+	}
+	if buf.Len() != 0 || copied != "foo\nbar" {
+		t.Errorf("expected all of the lines to be copied, got %q and %q", buf.String(), copied)
+	}
+}
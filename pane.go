@@ -0,0 +1,69 @@
+package peco
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// multiplexer is a terminal multiplexer that peco.OpenInPane can open
+// new panes in
+type multiplexer struct {
+	env  string // this variable is set when running inside it
+	name string
+	// split returns the command that runs `cmd` in a new pane
+	split func(cmd string) []string
+}
+
+var multiplexers = []multiplexer{
+	{"TMUX", "tmux", func(cmd string) []string {
+		// Given more than one argument, tmux runs the command as it
+		// is, rather than with its default-shell, which may not be
+		// able to run it
+		return append([]string{"tmux", "split-window", "--"}, shellCommand(cmd).Args...)
+	}},
+	{"WEZTERM_PANE", "wezterm", func(cmd string) []string {
+		return append([]string{"wezterm", "cli", "split-pane", "--"}, shellCommand(cmd).Args...)
+	}},
+}
+
+// _runSplit runs the command that opens a pane, returning what it
+// printed. Tests replace it, so that no panes are actually opened
+var _runSplit = func(args []string) ([]byte, error) {
+	return exec.Command(args[0], args[1:]...).CombinedOutput()
+}
+
+// detectMultiplexer returns the multiplexer that peco is running
+// inside, according to `getenv`
+func detectMultiplexer(getenv func(string) string) (multiplexer, error) {
+	for _, m := range multiplexers {
+		if getenv(m.env) != "" {
+			return m, nil
+		}
+	}
+	return multiplexer{}, fmt.Errorf("error: No supported terminal multiplexer detected")
+}
+
+// OpenInPane opens a new pane for each of `lines`, running PaneCommand
+// with "{}" replaced by the line. Returns the name of the multiplexer.
+// If one of the panes fails to open, the rest are not opened, and the
+// error tells how many were
+func (c *Ctx) OpenInPane(lines []string) (string, error) {
+	m, err := detectMultiplexer(os.Getenv)
+	if err != nil {
+		return "", err
+	}
+
+	for n, line := range lines {
+		args := m.split(expandCommandTemplate(c.config.PaneCommand, line))
+		if out, err := _runSplit(args); err != nil {
+			msg := strings.TrimSpace(string(out))
+			if msg == "" {
+				msg = err.Error()
+			}
+			return "", fmt.Errorf("error: Failed to open %s pane (%d of %d opened): %s", m.name, n, len(lines), msg)
+		}
+	}
+	return m.name, nil
+}
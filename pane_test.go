@@ -0,0 +1,67 @@
+package peco
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDetectMultiplexer(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(name string) string {
+		return env[name]
+	}
+
+	if _, err := detectMultiplexer(getenv); err == nil {
+		t.Errorf("expected no multiplexer to be detected")
+	}
+
+	env["WEZTERM_PANE"] = "0"
+	m, err := detectMultiplexer(getenv)
+	if err != nil || m.name != "wezterm" {
+		t.Fatalf("expected wezterm to be detected, got %s (%v)", m.name, err)
+	}
+	if args := m.split("less 'a b'"); len(args) < 5 || args[3] != "--" || args[len(args)-1] != "less 'a b'" {
+		t.Errorf("unexpected split command %q", args)
+	}
+
+	env["TMUX"] = "/tmp/tmux-1000/default,1,0"
+	m, err = detectMultiplexer(getenv)
+	if err != nil || m.name != "tmux" {
+		t.Fatalf("expected tmux to be detected, got %s (%v)", m.name, err)
+	}
+	if args := m.split("less 'a b'"); len(args) < 5 || args[2] != "--" || args[len(args)-1] != "less 'a b'" {
+		t.Errorf("unexpected split command %q", args)
+	}
+}
+
+func TestOpenInPanePartialFailure(t *testing.T) {
+	tmux := os.Getenv("TMUX")
+	os.Setenv("TMUX", "/tmp/tmux-1000/default,1,0")
+	defer os.Setenv("TMUX", tmux)
+
+	runSplit := _runSplit
+	defer func() { _runSplit = runSplit }()
+	splits := 0
+	_runSplit = func(args []string) ([]byte, error) {
+		splits++
+		if splits == 3 {
+			return []byte("no space for new pane\n"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+
+	c := newTestCtx()
+	c.config.PaneCommand = "less {}"
+	_, err := c.OpenInPane([]string{"a", "b", "c", "d"})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "2 of 4 opened") || !strings.Contains(msg, "no space for new pane") {
+		t.Errorf("expected the error to tell how many panes were opened, got '%s'", msg)
+	}
+	if splits != 3 {
+		t.Errorf("expected the rest of the panes to not be opened, got %d attempts", splits)
+	}
+}
@@ -0,0 +1,72 @@
+package peco
+
+// These are the values that can be specified in UnmatchedPinnedLines
+const (
+	// UnmatchedPinnedShow displays pinned lines that don't match the
+	// query using the PinnedUnmatched style
+	UnmatchedPinnedShow = "show"
+	// UnmatchedPinnedHide hides pinned lines that don't match the query
+	UnmatchedPinnedHide = "hide"
+)
+
+// unmatchedPin is a pinned line that is displayed even though it
+// doesn't match the query
+type unmatchedPin struct {
+	Match
+}
+
+// Pin pins the line, so that it's displayed above everything else
+func (c *Ctx) Pin(m Match) {
+	if c.pinned == nil {
+		c.pinned = map[int]bool{}
+	}
+	c.pinned[m.Index()] = true
+}
+
+// Unpin reverts the effect of Pin
+func (c *Ctx) Unpin(m Match) {
+	delete(c.pinned, m.Index())
+}
+
+// IsPinned returns true if the line has been pinned
+func (c *Ctx) IsPinned(m Match) bool {
+	return c.pinned[m.Index()]
+}
+
+// IsUnmatchedPin returns true if the line is only displayed because
+// it has been pinned
+func IsUnmatchedPin(m Match) bool {
+	_, ok := m.(unmatchedPin)
+	return ok
+}
+
+// pinMatches moves the pinned lines in `matches` to the top. Unless
+// UnmatchedPinnedLines is "hide", pinned lines that are not in
+// `matches` are added after them
+func (c *Ctx) pinMatches(matches []Match) []Match {
+	if len(c.pinned) == 0 {
+		return matches
+	}
+
+	pinned := []Match{}
+	rest := make([]Match, 0, len(matches))
+	found := map[int]bool{}
+	for _, m := range matches {
+		if c.IsPinned(m) {
+			pinned = append(pinned, m)
+			found[m.Index()] = true
+		} else {
+			rest = append(rest, m)
+		}
+	}
+
+	if c.config.UnmatchedPinnedLines != UnmatchedPinnedHide && len(found) < len(c.pinned) {
+		for _, m := range c.bufferLines() {
+			if c.IsPinned(m) && !found[m.Index()] {
+				pinned = append(pinned, unmatchedPin{m})
+			}
+		}
+	}
+
+	return append(pinned, rest...)
+}
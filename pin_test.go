@@ -0,0 +1,51 @@
+package peco
+
+import "testing"
+
+func TestPinMatches(t *testing.T) {
+	c := newTestCtx()
+	c.lines = []Match{
+		NewNoMatch("foo", false, 0),
+		NewNoMatch("bar", false, 1),
+		NewNoMatch("baz", false, 2),
+	}
+	c.Pin(c.lines[2])
+	c.Pin(c.lines[0])
+
+	// "foo" doesn't match, but is still displayed after "baz"
+	ordered := c.orderMatches(c.lines[1:])
+	if len(ordered) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(ordered))
+	}
+	for n, expected := range []int{2, 0, 1} {
+		if ordered[n].Index() != expected {
+			t.Errorf("expected line %d to be %d, got %d", n, expected, ordered[n].Index())
+		}
+	}
+	if !IsUnmatchedPin(ordered[1]) || IsUnmatchedPin(ordered[0]) {
+		t.Errorf("expected only 'foo' to be marked as unmatched")
+	}
+
+	c.config.UnmatchedPinnedLines = UnmatchedPinnedHide
+	if ordered := c.orderMatches(c.lines[1:]); len(ordered) != 2 {
+		t.Errorf("expected unmatched pinned lines to be hidden, got %d lines", len(ordered))
+	}
+}
+
+func TestPinsPerBuffer(t *testing.T) {
+	c := newTestCtx()
+	c.lines = []Match{NewNoMatch("foo", false, 0), NewNoMatch("bar", false, 1)}
+	c.Pin(c.lines[1])
+
+	// Index 1 of the pushed buffer is another line altogether
+	c.PushBuffer("drill", []Match{NewNoMatch("child", false, 0), NewNoMatch("other", false, 1)})
+	if c.IsPinned(c.lines[1]) {
+		t.Errorf("expected the pins to not apply to the pushed buffer")
+	}
+	c.Pin(c.lines[0])
+
+	c.PopBuffer()
+	if !c.IsPinned(c.lines[1]) || c.IsPinned(c.lines[0]) {
+		t.Errorf("expected the pins of the buffer to be restored, got %v", c.pinned)
+	}
+}
@@ -0,0 +1,328 @@
+package peco
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// previewStore holds the text displayed in the preview pane. Previews
+// are either provided along with the input (see --input-json), or
+// created by running PreviewCommand
+type previewStore struct {
+	mutex sync.Mutex
+	// provided holds the previews from the input, keyed by the
+	// index of the line
+	provided map[int]string
+	// results holds the output of PreviewCommand, keyed by the
+	// arguments that it was run with
+	results map[string]string
+	// finished holds the keys of the results that are done, least
+	// recently used first, so that they can be evicted (see
+	// previewCacheSize)
+	finished []string
+	// latest is the key of the preview that was asked for last. The
+	// others are stale, and are not run (or are killed if running)
+	latest string
+	// timer runs PreviewCommand for latest, once PreviewDelay has
+	// passed without the current line changing
+	timer *time.Timer
+	// running holds the commands that are running, keyed like results
+	running map[string]*exec.Cmd
+	// slots limits the number of commands that run at the same
+	// time to MaxPreviewProcesses. It's nil if there's no limit
+	slots chan struct{}
+}
+
+// previewLoading is displayed until the preview is available
+const previewLoading = "Loading preview..."
+
+// previewCacheSize is the number of outputs of PreviewCommand that are
+// kept. Once there are more, the least recently used one is evicted,
+// and is run again if it's needed
+const previewCacheSize = 100
+
+func newPreviewStore() *previewStore {
+	return &previewStore{
+		provided: map[int]string{},
+		results:  map[string]string{},
+		running:  map[string]*exec.Cmd{},
+	}
+}
+
+// SetPreview sets the preview for the line at `index` in the input
+func (c *Ctx) SetPreview(index int, preview string) {
+	c.previews.mutex.Lock()
+	defer c.previews.mutex.Unlock()
+	c.previews.provided[index] = preview
+}
+
+// Preview returns the preview of the line. If there's no preview
+// provided by the input, PreviewCommand is run in the background (once
+// PreviewDelay has passed), and the screen is redrawn once it's done.
+// Returns false if no preview can be created for the line
+func (c *Ctx) Preview(m Match) (string, bool) {
+	p := c.previews
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Previews from the input only apply to the original buffer
+	if c.IsRootBuffer() {
+		if preview, ok := p.provided[m.Index()]; ok {
+			return preview, true
+		}
+	}
+
+	if len(c.config.PreviewCommand) == 0 {
+		return "", false
+	}
+
+	args := c.expandPreviewArgs(m)
+	key := strings.Join(args, "\x00")
+	if preview, ok := p.results[key]; ok {
+		p.latest = key
+		p.touch(key)
+		return preview, true
+	}
+	if key == p.latest {
+		// Waiting for PreviewDelay to pass
+		return previewLoading, true
+	}
+	p.latest = key
+
+	// The previews that are no longer wanted are killed, and are run
+	// again if they are wanted later
+	for k, cmd := range p.running {
+		cmd.Process.Kill()
+		delete(p.running, k)
+		delete(p.results, k)
+	}
+
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if d := time.Duration(c.config.PreviewDelay) * time.Millisecond; d > 0 {
+		// Only run the command once the current line stops changing
+		p.timer = time.AfterFunc(d, func() {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			if p.latest == key {
+				c.runPreview(key, args)
+			}
+		})
+		return previewLoading, true
+	}
+
+	c.runPreview(key, args)
+	return previewLoading, true
+}
+
+// runPreview runs PreviewCommand with `args` in the background, and
+// redraws the screen once it's done. Must be called with the mutex of
+// the preview store held
+func (c *Ctx) runPreview(key string, args []string) {
+	p := c.previews
+	if max := c.config.MaxPreviewProcesses; max > 0 && p.slots == nil {
+		p.slots = make(chan struct{}, max)
+	}
+
+	// Mark it as being run, so that we don't run it more than once
+	p.results[key] = previewLoading
+	go func() {
+		if p.slots != nil {
+			p.slots <- struct{}{}
+			defer func() { <-p.slots }()
+		}
+
+		var out bytes.Buffer
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+
+		p.mutex.Lock()
+		if p.latest != key {
+			// It became stale while waiting for a slot
+			delete(p.results, key)
+			p.mutex.Unlock()
+			return
+		}
+		if err := cmd.Start(); err != nil {
+			p.finish(key, err.Error())
+			p.mutex.Unlock()
+			c.DrawMatches(nil)
+			return
+		}
+		p.running[key] = cmd
+		p.mutex.Unlock()
+
+		err := cmd.Wait()
+
+		p.mutex.Lock()
+		if p.running[key] != cmd {
+			// It was killed, so what it printed is incomplete
+			p.mutex.Unlock()
+			return
+		}
+		delete(p.running, key)
+		preview := out.String()
+		if err != nil && len(preview) == 0 {
+			preview = err.Error()
+		}
+		p.finish(key, preview)
+		p.mutex.Unlock()
+		c.DrawMatches(nil)
+	}()
+}
+
+// finish stores `preview` as the result for `key`, evicting the least
+// recently used result if there are more than previewCacheSize. Must
+// be called with the mutex held
+func (p *previewStore) finish(key, preview string) {
+	p.results[key] = preview
+	p.finished = append(p.finished, key)
+	if len(p.finished) > previewCacheSize {
+		delete(p.results, p.finished[0])
+		p.finished = p.finished[1:]
+	}
+}
+
+// touch marks the result for `key` as the most recently used one, if
+// it's done. Must be called with the mutex held
+func (p *previewStore) touch(key string) {
+	for n, k := range p.finished {
+		if k == key {
+			copy(p.finished[n:], p.finished[n+1:])
+			p.finished[len(p.finished)-1] = key
+			return
+		}
+	}
+}
+
+// expandPreviewArgs returns the arguments of PreviewCommand for `m`.
+// Besides "$LINE", the placeholders "{}" (the line), "{n}" (the index
+// of the line in the input, starting from 0), "{q}" (the query) and
+// "{1}", "{2}", ... (the columns of the line, see ColumnDelimiter) are
+// replaced within each argument
+func (c *Ctx) expandPreviewArgs(m Match) []string {
+	args := expandLineArgs(c.config.PreviewCommand, m.Output())
+	lookup := c.lineLookup(m)
+	for i, arg := range args {
+		args[i] = expandPlaceholders(arg, lookup)
+	}
+	return args
+}
+
+// lineLookup returns the function that expandPlaceholders uses to look
+// up the placeholders of PreviewCommand for `m`
+func (c *Ctx) lineLookup(m Match) func(string) (string, bool) {
+	line := m.Output()
+	columns := splitColumns(line, c.columnDelimiter())
+	return func(name string) (string, bool) {
+		switch name {
+		case "":
+			return line, true
+		case "n":
+			return strconv.Itoa(m.Index()), true
+		case "q":
+			return string(c.query), true
+		}
+		n, err := strconv.Atoi(name)
+		if err != nil || n < 1 {
+			return "", false
+		}
+		if n > len(columns) {
+			return "", true
+		}
+		return strings.TrimSpace(columns[n-1]), true
+	}
+}
+
+// expandPlaceholders replaces each "{name}" in `s` with the value
+// returned by `lookup`. Placeholders that `lookup` doesn't know are
+// left as they are, and "{{" is a literal "{"
+func expandPlaceholders(s string, lookup func(string) (string, bool)) string {
+	var buf bytes.Buffer
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			buf.WriteString(s)
+			return buf.String()
+		}
+		buf.WriteString(s[:i])
+		s = s[i:]
+
+		if strings.HasPrefix(s, "{{") {
+			buf.WriteByte('{')
+			s = s[2:]
+			continue
+		}
+
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			buf.WriteString(s)
+			return buf.String()
+		}
+		if v, ok := lookup(s[1:end]); ok {
+			buf.WriteString(v)
+		} else {
+			buf.WriteString(s[:end+1])
+		}
+		s = s[end+1:]
+	}
+}
+
+// IsPreviewEnabled returns true if the preview pane is displayed
+func (c *Ctx) IsPreviewEnabled() bool {
+	return c.showPreview
+}
+
+// locationRegexp matches the line number in a location such as
+// "file:12" or "file:12:5: message"
+var locationRegexp = regexp.MustCompile(`:(\d+)(?::|$)`)
+
+// locationLineNumber returns the line number in `line`, if it looks
+// like the location of a line in a file (e.g. the output of grep -n)
+func locationLineNumber(line string) (int, bool) {
+	m := locationRegexp.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// previewOffset returns the first of the `total` lines of the preview to
+// display in `rows` rows, so that `lineno` (starting from 1) is in the
+// middle. If `lineno` is 0, the preview is displayed from the top
+func previewOffset(lineno, rows, total int) int {
+	if lineno < 1 || total <= rows {
+		return 0
+	}
+	return clampPreviewOffset(lineno-1-rows/2, rows, total)
+}
+
+// clampPreviewOffset limits `offset` so that the preview doesn't start
+// before its first line, or leave rows empty after its last line
+func clampPreviewOffset(offset, rows, total int) int {
+	if offset > total-rows {
+		offset = total - rows
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return offset
+}
+
+// previewLines splits the preview into the lines to be displayed
+func previewLines(preview string) []string {
+	preview = strings.Replace(preview, "\t", "    ", -1)
+	return strings.Split(strings.TrimRight(preview, "\n"), "\n")
+}
@@ -0,0 +1,149 @@
+package peco
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadJSONInput(t *testing.T) {
+	input := `[{"text": "foo", "preview": "This is foo"}, {"text": "bar"}]`
+	ch := make(chan inputLine, 10)
+	if err := readJSONInput(strings.NewReader(input), ch); err != nil {
+		t.Fatalf("Failed to read JSON input: %s", err)
+	}
+	close(ch)
+
+	lines := []inputLine{}
+	for l := range ch {
+		lines = append(lines, l)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	if lines[0].text != "foo" || lines[0].preview == nil || *lines[0].preview != "This is foo" {
+		t.Errorf("unexpected first line %#v", lines[0])
+	}
+	if lines[1].text != "bar" || lines[1].preview != nil {
+		t.Errorf("expected second line to have no preview, got %#v", lines[1])
+	}
+}
+
+func TestPreview(t *testing.T) {
+	c := newTestCtx()
+	c.SetPreview(0, "This is foo")
+
+	if p, ok := c.Preview(NewNoMatch("foo", false, 0)); !ok || p != "This is foo" {
+		t.Errorf("expected provided preview, got '%s'", p)
+	}
+
+	// No preview, and no PreviewCommand to fall back to
+	if _, ok := c.Preview(NewNoMatch("bar", false, 1)); ok {
+		t.Errorf("expected no preview to be available")
+	}
+}
+
+func TestExpandPreviewArgs(t *testing.T) {
+	c := newTestCtx()
+	c.SetQuery([]rune("fix"))
+	c.config.PreviewCommand = []string{"show", "{1}", "--line={n}", "{q}", "{}", "{3}", "{{1}", "{x}", "$LINE"}
+
+	args := c.expandPreviewArgs(NewNoMatch("abc123 fix typo", false, 4))
+	expected := []string{"show", "abc123", "--line=4", "fix", "abc123 fix typo", "typo", "{1}", "{x}", "abc123 fix typo"}
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, args)
+	}
+}
+
+func TestPreviewDelay(t *testing.T) {
+	c := newTestCtx()
+	c.config.PreviewCommand = []string{"echo", "{}"}
+	c.config.PreviewDelay = 50
+	go func() {
+		for range c.DrawCh() {
+		}
+	}()
+
+	// Moving on before the delay passes means the command is never run
+	// for the first line
+	for _, l := range []string{"foo", "bar"} {
+		if p, ok := c.Preview(NewNoMatch(l, false, 0)); !ok || p != previewLoading {
+			t.Errorf("expected '%s' to be loading, got '%s'", l, p)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p, _ := c.Preview(NewNoMatch("bar", false, 0))
+		if p == "bar\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the preview of 'bar' to be created, got '%s'", p)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.previews.mutex.Lock()
+	defer c.previews.mutex.Unlock()
+	if _, ok := c.previews.results["echo\x00foo"]; ok {
+		t.Errorf("expected the preview of 'foo' to not be created")
+	}
+}
+
+func TestLocationLineNumber(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected int
+	}{
+		{"main.go:42", 42},
+		{"main.go:42: undefined: foo", 42},
+		{"main.go:42:5: undefined: foo", 42},
+		{`C:\src\main.go:7:fmt.Println()`, 7},
+		{"main.go", 0},
+		{"12:30 meeting", 0},
+	}
+	for _, test := range tests {
+		if n, _ := locationLineNumber(test.line); n != test.expected {
+			t.Errorf("expected line number of '%s' to be %d, got %d", test.line, test.expected, n)
+		}
+	}
+}
+
+func TestPreviewOffset(t *testing.T) {
+	tests := []struct {
+		lineno, rows, total int
+		expected            int
+	}{
+		{0, 10, 100, 0},
+		{3, 10, 100, 0},
+		{50, 10, 100, 44},
+		{99, 10, 100, 90},
+		{5, 10, 8, 0},
+	}
+	for _, test := range tests {
+		if got := previewOffset(test.lineno, test.rows, test.total); got != test.expected {
+			t.Errorf("expected offset for line %d of %d in %d rows to be %d, got %d", test.lineno, test.total, test.rows, test.expected, got)
+		}
+	}
+}
+
+func TestPreviewCacheSize(t *testing.T) {
+	p := newPreviewStore()
+	for n := 0; n < previewCacheSize; n++ {
+		p.finish(strconv.Itoa(n), "preview")
+	}
+	p.touch("0")
+	p.finish("new", "preview")
+
+	if len(p.results) != previewCacheSize {
+		t.Errorf("expected %d results to be kept, got %d", previewCacheSize, len(p.results))
+	}
+	if _, ok := p.results["0"]; !ok {
+		t.Errorf("expected the recently used result to be kept")
+	}
+	if _, ok := p.results["1"]; ok {
+		t.Errorf("expected the least recently used result to be evicted")
+	}
+}
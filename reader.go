@@ -2,9 +2,11 @@ package peco
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -33,16 +35,23 @@ func (b *BufferReader) Loop() {
 	defer func() { recover() }()             // ignore errors
 	defer func() { close(b.inputReadyCh) }() // Make sure to close notifier
 
-	ch := make(chan string, 10)
+	ch := make(chan inputLine, 10)
 
 	// scanner.Scan() blocks until the next read or error. But we want to
 	// exit immediately, so we move it out to its own goroutine
 	go func() {
 		defer func() { recover() }()
 		defer func() { close(ch) }()
-		scanner := bufio.NewScanner(b.input)
+		input := b.decodeInput(b.input)
+		if b.inputJSON {
+			if err := readJSONInput(input, ch); err != nil {
+				ch <- inputLine{"", nil, err}
+			}
+			return
+		}
+		scanner := bufio.NewScanner(input)
 		for scanner.Scan() {
-			ch <- scanner.Text()
+			ch <- inputLine{scanner.Text(), nil, nil}
 		}
 	}()
 
@@ -50,6 +59,13 @@ func (b *BufferReader) Loop() {
 	once := &sync.Once{}
 	var refresh *time.Timer
 
+	// index keeps counting even when old lines are removed from the
+	// buffer, so that it always points to the line in the original input
+	index := 0
+	var readErr error
+	// sample holds the first lines for AutoDelimiter. It's set to nil
+	// once the delimiter has been detected
+	sample := make([]string, 0, delimiterSampleSize)
 	loop := true
 	for loop {
 		select {
@@ -60,22 +76,33 @@ func (b *BufferReader) Loop() {
 				loop = false
 				continue
 			}
+			if line.err != nil {
+				readErr = line.err
+				continue
+			}
 
-			if line != "" {
+			if line.text != "" {
 				once.Do(func() { b.inputReadyCh <- struct{}{} })
-				m.Lock()
-				b.lines = append(b.lines, NewNoMatch(line, b.enableSep))
-				if b.IsBufferOverflowing() {
-					b.lines = b.lines[1:]
+				b.appendInput(NewNoMatch(line.text, b.enableSep, index))
+				if line.preview != nil {
+					b.SetPreview(index, *line.preview)
+				}
+				index++
+
+				if sample != nil {
+					sample = append(sample, line.text)
+					if len(sample) == delimiterSampleSize {
+						b.setDetectedDelimiter(sample)
+						sample = nil
+					}
 				}
-				m.Unlock()
 			}
 
 			m.Lock()
 			if refresh == nil {
 				refresh = time.AfterFunc(100*time.Millisecond, func() {
 					if !b.ExecQuery() {
-						b.DrawMatches(b.lines)
+						b.DrawMatches(b.emptyQueryMatches())
 					}
 					m.Lock()
 					refresh = nil
@@ -87,11 +114,106 @@ func (b *BufferReader) Loop() {
 	}
 
 	b.input.Close()
+	if sample != nil {
+		b.setDetectedDelimiter(sample)
+	}
 
 	// Out of the reader loop. If at this point we have no buffer,
-	// that means we have no buffer, so we should quit.
-	if len(b.lines) == 0 {
+	// that means we have no buffer, so we should quit, unless we've
+	// been asked to let the user know
+	b.bufferMutex.Lock()
+	b.inputDone = true
+	b.bufferMutex.Unlock()
+	b.logger.logf(LogInfo, "input", "lines", index)
+	if readErr != nil {
+		b.logger.logf(LogError, "input", "error", readErr.Error())
+	}
+	if b.rootBufferLen() == 0 {
+		if readErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read JSON input: %s\n", readErr)
+		}
+		if b.config.OnEmptyInput != EmptyInputExit {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "No buffer to work with was available\n")
 		b.ExitWith(1)
-		fmt.Fprintf(os.Stderr, "No buffer to work with was available")
 	}
 }
+
+// These are the values for Config.OnEmptyInput
+const (
+	// EmptyInputExit exits with a failure status when the input
+	// ends without any lines
+	EmptyInputExit = "exit"
+	// EmptyInputShowMessage displays Config.EmptyInputMessage, and
+	// waits for the user to exit
+	EmptyInputShowMessage = "message"
+	// EmptyInputShowStderr displays what the source command (see
+	// StartSourceCommand) wrote to stderr, and waits for the user to
+	// exit. Config.EmptyInputMessage is displayed if there's nothing
+	EmptyInputShowStderr = "stderr"
+)
+
+// SetOnEmptyInput specifies what happens when the input ends without
+// any lines. See EmptyInputExit, EmptyInputShowMessage and
+// EmptyInputShowStderr
+func (c *Ctx) SetOnEmptyInput(s string) error {
+	switch s {
+	case EmptyInputExit, EmptyInputShowMessage, EmptyInputShowStderr:
+		c.config.OnEmptyInput = s
+		return nil
+	}
+	return fmt.Errorf("error: Invalid OnEmptyInput '%s'", s)
+}
+
+// IsInputEmpty returns true if the input has ended without any lines.
+// While the input is still being read, this is always false
+func (c *Ctx) IsInputEmpty() bool {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return c.inputDone && len(*c.rootBuffer()) == 0
+}
+
+// emptyInputMessage returns the lines that are displayed when the
+// input is empty (see OnEmptyInput)
+func (c *Ctx) emptyInputMessage() []string {
+	if c.config.OnEmptyInput == EmptyInputShowStderr {
+		c.bufferMutex.Lock()
+		stderr := strings.TrimRight(c.sourceStderr, "\n")
+		c.bufferMutex.Unlock()
+		if stderr != "" {
+			return strings.Split(stderr, "\n")
+		}
+	}
+	return []string{c.config.EmptyInputMessage}
+}
+
+// inputLine is a line read from the input. preview is nil unless
+// the input provided one. If the input couldn't be read, err is set
+// instead, and nothing is sent afterwards
+type inputLine struct {
+	text    string
+	preview *string
+	err     error
+}
+
+// readJSONInput reads a JSON array of objects with "text" and an
+// optional "preview" from `r`, and sends them through `ch`
+func readJSONInput(r io.Reader, ch chan<- inputLine) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		var item struct {
+			Text    string  `json:"text"`
+			Preview *string `json:"preview"`
+		}
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		ch <- inputLine{item.Text, item.Preview, nil}
+	}
+	return nil
+}
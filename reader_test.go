@@ -0,0 +1,86 @@
+package peco
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readEmptyInput(t *testing.T, onEmptyInput string) *Ctx {
+	c := newTestCtx()
+	if err := c.SetOnEmptyInput(onEmptyInput); err != nil {
+		t.Fatalf("failed to set OnEmptyInput: %s", err)
+	}
+
+	r := c.NewBufferReader(ioutil.NopCloser(strings.NewReader("")))
+	if c.IsInputEmpty() {
+		t.Errorf("expected input to not be empty before it is read")
+	}
+	c.AddWaitGroup(1)
+	go r.Loop()
+	<-r.InputReadyCh()
+	return c
+}
+
+func TestEmptyInput(t *testing.T) {
+	c := readEmptyInput(t, EmptyInputExit)
+	if !c.IsInputEmpty() {
+		t.Errorf("expected input to be empty")
+	}
+	select {
+	case <-c.LoopCh():
+	default:
+		t.Errorf("expected peco to exit")
+	}
+	if c.ExitStatus != 1 {
+		t.Errorf("expected exit status to be 1, got %d", c.ExitStatus)
+	}
+
+	c = readEmptyInput(t, EmptyInputShowMessage)
+	if !c.IsInputEmpty() {
+		t.Errorf("expected input to be empty")
+	}
+	select {
+	case <-c.LoopCh():
+		t.Errorf("expected peco to wait for the user")
+	default:
+	}
+
+	if err := c.SetOnEmptyInput("ignore"); err == nil {
+		t.Errorf("expected an error for an invalid OnEmptyInput")
+	}
+}
+
+func TestEmptyInputStderr(t *testing.T) {
+	c := newTestCtx()
+	if err := c.SetOnEmptyInput(EmptyInputShowStderr); err != nil {
+		t.Fatalf("failed to set OnEmptyInput: %s", err)
+	}
+
+	in, err := c.StartSourceCommand("echo 'no such file' >&2")
+	if err != nil {
+		t.Fatalf("failed to start the source command: %s", err)
+	}
+	r := c.NewBufferReader(in)
+	c.AddWaitGroup(1)
+	go r.Loop()
+	<-r.InputReadyCh()
+
+	if !c.IsInputEmpty() {
+		t.Errorf("expected input to be empty")
+	}
+	select {
+	case <-c.LoopCh():
+		t.Errorf("expected peco to wait for the user")
+	default:
+	}
+	if msg := c.emptyInputMessage(); len(msg) != 1 || msg[0] != "no such file" {
+		t.Errorf("expected the stderr of the command to be displayed, got %q", msg)
+	}
+
+	// Without any stderr, EmptyInputMessage is displayed instead
+	c = readEmptyInput(t, EmptyInputShowStderr)
+	if msg := c.emptyInputMessage(); len(msg) != 1 || msg[0] != c.config.EmptyInputMessage {
+		t.Errorf("expected EmptyInputMessage to be displayed, got %q", msg)
+	}
+}
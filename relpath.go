@@ -0,0 +1,63 @@
+package peco
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// RelativePathConfig controls how peco.CopyRelativePath converts
+// the lines into relative paths
+type RelativePathConfig struct {
+	// Base is the directory that the paths are made relative to.
+	// By default, the current directory
+	Base string `json:"Base"`
+	// Always converts every line. By default, lines that are not
+	// existing paths are left untouched
+	Always bool `json:"Always"`
+}
+
+// relativePaths converts `lines` into paths relative to the configured
+// base directory
+func (c *Ctx) relativePaths(lines []string) ([]string, error) {
+	base := c.config.RelativePath.Base
+	if base == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		base = wd
+	}
+
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(lines))
+	for n, line := range lines {
+		paths[n] = relativePath(line, base, c.config.RelativePath.Always)
+	}
+	return paths, nil
+}
+
+// relativePath returns `line` as a path relative to `base`, which must
+// be an absolute path. Unless `always` is true, `line` is returned
+// as is if it's not an existing path
+func relativePath(line, base string, always bool) string {
+	abs, err := filepath.Abs(line)
+	if err != nil {
+		return line
+	}
+
+	if !always {
+		if _, err := os.Stat(abs); err != nil {
+			return line
+		}
+	}
+
+	rel, err := filepath.Rel(base, abs)
+	if err != nil {
+		return line
+	}
+	return rel
+}
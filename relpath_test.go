@@ -0,0 +1,37 @@
+package peco
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelativePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-relpath")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "sub", "file.txt")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	if err := ioutil.WriteFile(file, []byte{}, 0644); err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+
+	base := filepath.Join(dir, "sub")
+	if p := relativePath(file, base, false); p != "file.txt" {
+		t.Errorf("expected 'file.txt', got '%s'", p)
+	}
+
+	missing := filepath.Join(dir, "missing.txt")
+	if p := relativePath(missing, base, false); p != missing {
+		t.Errorf("expected non-existing path to be left untouched, got '%s'", p)
+	}
+	if p := relativePath(missing, base, true); p != filepath.Join("..", "missing.txt") {
+		t.Errorf("expected '../missing.txt', got '%s'", p)
+	}
+}
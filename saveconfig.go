@@ -0,0 +1,39 @@
+package peco
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// SnapshotConfig returns a copy of the config as it was read from the
+// file, updated with the settings that have been changed while peco is
+// running. The command line options (e.g. --prompt) are not included
+func (c *Ctx) SnapshotConfig() Config {
+	cfg := *NewConfig()
+	if c.fileConfig != nil {
+		cfg = *c.fileConfig
+	}
+	if c.matcherSwitched {
+		cfg.Matcher = c.Matcher().String()
+	}
+	cfg.SortColumn = c.sortColumn
+	cfg.SortDescending = c.sortDescending
+	cfg.RelativeNumbers = c.relativeNumbers
+	cfg.ShowInfoLine = c.showInfoLine
+	cfg.Compact = c.compact
+	cfg.MatchDescription = c.matchDescription
+	cfg.ShowOutputPreview = c.showOutputPreview
+	cfg.ShowPreview = c.showPreview
+	cfg.PreviewToMatch = c.previewToMatch
+	return cfg
+}
+
+// SaveConfig writes the current config to `filename`, in the same
+// format that ReadConfig reads
+func (c *Ctx) SaveConfig(filename string) error {
+	buf, err := json.MarshalIndent(c.SnapshotConfig(), "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(buf, '\n'), 0644)
+}
@@ -1,43 +1,112 @@
 package peco
 
-import "sort"
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
 
 // Selection stores the line numbers that were selected by the user.
 // The contents of the Selection is always sorted from smallest to
-// largest line number
+// largest line number.
+//
+// The selection of the user (Ctx.selection) holds the indices of the
+// lines in the input (see Match.Index) rather than their positions on
+// the screen, so that it's not affected by the lines being filtered
+// or sorted
 type Selection []int
 
 // Has returns true if line `v` is in the selection
 func (s Selection) Has(v int) bool {
-	for _, i := range []int(s) {
-		if i == v {
-			return true
-		}
-	}
-	return false
+	k := sort.SearchInts([]int(s), v)
+	return k < len(s) && s[k] == v
 }
 
 // Add adds a new line number to the selection. If the line already
 // exists in the selection, it is silently ignored
 func (s *Selection) Add(v int) {
-	if s.Has(v) {
+	a := []int(*s)
+	k := sort.SearchInts(a, v)
+	if k < len(a) && a[k] == v {
 		return
 	}
-	*s = Selection(append([]int(*s), v))
-	sort.Sort(s)
+	a = append(a, 0)
+	copy(a[k+1:], a[k:])
+	a[k] = v
+	*s = Selection(a)
 }
 
 // Remove removes the specified line number from the selection
 func (s *Selection) Remove(v int) {
 	a := []int(*s)
-	for k, i := range a {
-		if i == v {
-			tmp := a[:k]
-			tmp = append(tmp, a[k+1:]...)
-			*s = Selection(tmp)
-			return
+	k := sort.SearchInts(a, v)
+	if k < len(a) && a[k] == v {
+		*s = Selection(append(a[:k], a[k+1:]...))
+	}
+}
+
+// Next returns the first line number in the selection that is
+// greater than `v`. If `wrap` is true and there's no such line, the
+// smallest line number is returned. Returns false if nothing was found
+func (s Selection) Next(v int, wrap bool) (int, bool) {
+	for _, i := range []int(s) {
+		if i > v {
+			return i, true
+		}
+	}
+	if wrap && len(s) > 0 {
+		return s[0], true
+	}
+	return 0, false
+}
+
+// Prev returns the last line number in the selection that is less
+// than `v`. If `wrap` is true and there's no such line, the largest
+// line number is returned. Returns false if nothing was found
+func (s Selection) Prev(v int, wrap bool) (int, bool) {
+	for k := len(s) - 1; k >= 0; k-- {
+		if s[k] < v {
+			return s[k], true
+		}
+	}
+	if wrap && len(s) > 0 {
+		return s[len(s)-1], true
+	}
+	return 0, false
+}
+
+// Union returns the line numbers that are in either `s` or `o`
+func (s Selection) Union(o Selection) Selection {
+	u := make(Selection, 0, len(s)+len(o))
+	for _, v := range s {
+		u.Add(v)
+	}
+	for _, v := range o {
+		u.Add(v)
+	}
+	return u
+}
+
+// Intersection returns the line numbers that are in both `s` and `o`
+func (s Selection) Intersection(o Selection) Selection {
+	x := Selection{}
+	for _, v := range s {
+		if o.Has(v) {
+			x = append(x, v)
+		}
+	}
+	return x
+}
+
+// Difference returns the line numbers that are in `s` but not in `o`
+func (s Selection) Difference(o Selection) Selection {
+	d := Selection{}
+	for _, v := range s {
+		if !o.Has(v) {
+			d = append(d, v)
 		}
 	}
+	return d
 }
 
 // Clear empties the selection
@@ -61,3 +130,157 @@ func (s Selection) Swap(i, j int) {
 func (s Selection) Less(i, j int) bool {
 	return s[i] < s[j]
 }
+
+// These are the values that can be specified in SelectionOnQueryChange
+const (
+	// SelectionClear clears the selection when the query changes
+	SelectionClear = "clear"
+	// SelectionPersist keeps the selection when the query changes.
+	// Selected lines that no longer match stay selected, and are
+	// selected again when they match
+	SelectionPersist = "persist"
+)
+
+// These are the values that can be specified in VisualSelect
+const (
+	// VisualSelectAdd adds the lines selected by peco.StartVisualSelect
+	// to the existing selection
+	VisualSelectAdd = "add"
+	// VisualSelectReplace clears the existing selection when
+	// peco.StartVisualSelect is executed
+	VisualSelectReplace = "replace"
+)
+
+// lineAt returns the line at `lineno` in the displayed lines, or nil
+func (c *Ctx) lineAt(lineno int) Match {
+	targets := c.currentTargets()
+	if lineno < 1 || lineno > len(targets) {
+		return nil
+	}
+	return targets[lineno-1]
+}
+
+// SelectLine adds the line at `lineno` in the displayed lines to the
+// selection
+func (c *Ctx) SelectLine(lineno int) {
+	if m := c.lineAt(lineno); m != nil {
+		c.selection.Add(m.Index())
+	}
+}
+
+// DeselectLine removes the line at `lineno` in the displayed lines
+// from the selection
+func (c *Ctx) DeselectLine(lineno int) {
+	if m := c.lineAt(lineno); m != nil {
+		c.selection.Remove(m.Index())
+	}
+}
+
+// IsLineSelected returns true if the line at `lineno` in the displayed
+// lines is selected
+func (c *Ctx) IsLineSelected(lineno int) bool {
+	m := c.lineAt(lineno)
+	return m != nil && c.selection.Has(m.Index())
+}
+
+// selectedLineNumbers returns the positions of the selected lines in
+// the displayed lines
+func (c *Ctx) selectedLineNumbers() Selection {
+	s := Selection{}
+	for n, m := range c.currentTargets() {
+		if c.selection.Has(m.Index()) {
+			s = append(s, n+1)
+		}
+	}
+	return s
+}
+
+// BookmarkSelection saves a copy of the selection as `name`, so that it
+// can be combined with the selection later (see peco.SelectionDiff)
+func (c *Ctx) BookmarkSelection(name string) {
+	if c.bookmarks == nil {
+		c.bookmarks = map[string]Selection{}
+	}
+	c.bookmarks[name] = append(Selection{}, c.selection...)
+}
+
+// combineBookmark replaces the selection with the result of `op`
+// applied to the selection and the bookmark `name`. Returns false if
+// there's no such bookmark
+func (c *Ctx) combineBookmark(name string, op func(Selection, Selection) Selection) bool {
+	b, ok := c.bookmarks[name]
+	if !ok {
+		return false
+	}
+	c.selection = op(c.selection, b)
+	return true
+}
+
+// queryChanged is called whenever the query may have changed. If
+// SelectionOnQueryChange is "clear", the selection is cleared when
+// the query is not the same as the last time
+func (c *Ctx) queryChanged() {
+	q := string(c.query)
+	if q != c.lastQuery && c.config.SelectionOnQueryChange == SelectionClear {
+		c.selection.Clear()
+	}
+	c.lastQuery = q
+}
+
+// similarTo returns a function that tells whether a line is similar to
+// `m`, according to SimilarLines
+func (c *Ctx) similarTo(m Match) (func(Match) bool, error) {
+	rule := c.config.SimilarLines
+	if rule.Pattern != "" {
+		lookup := c.lineLookup(m)
+		pattern := expandPlaceholders(rule.Pattern, func(name string) (string, bool) {
+			v, ok := lookup(name)
+			return regexp.QuoteMeta(v), ok
+		})
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return func(l Match) bool {
+			return re.MatchString(l.Output())
+		}, nil
+	}
+
+	delim := c.columnDelimiter()
+	column := func(l Match) (string, bool) {
+		columns := splitColumns(l.Output(), delim)
+		if rule.Field > len(columns) {
+			return "", false
+		}
+		return strings.TrimSpace(columns[rule.Field-1]), true
+	}
+	field, ok := column(m)
+	return func(l Match) bool {
+		v, found := column(l)
+		return ok && found && v == field
+	}, nil
+}
+
+// SelectSimilar adds the current line, and the lines in the buffer that
+// are similar to it (see SimilarLines) to the selection, and returns
+// how many were added
+func (c *Ctx) SelectSimilar() (int, error) {
+	m := c.CurrentMatch()
+	if m == nil {
+		return 0, nil
+	}
+
+	similar, err := c.similarTo(m)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, l := range c.bufferLines() {
+		if !c.selection.Has(l.Index()) && (l.Index() == m.Index() || similar(l)) {
+			c.selection.Add(l.Index())
+			added++
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,156 @@
+package peco
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectionNextPrev(t *testing.T) {
+	s := Selection([]int{3, 7, 10})
+
+	if l, ok := s.Next(3, false); !ok || l != 7 {
+		t.Errorf("expected Next(3) = 7, got %d", l)
+	}
+	if _, ok := s.Next(10, false); ok {
+		t.Errorf("expected Next(10) without wrap to fail")
+	}
+	if l, ok := s.Next(10, true); !ok || l != 3 {
+		t.Errorf("expected Next(10) with wrap = 3, got %d", l)
+	}
+	if l, ok := s.Prev(7, false); !ok || l != 3 {
+		t.Errorf("expected Prev(7) = 3, got %d", l)
+	}
+	if l, ok := s.Prev(1, true); !ok || l != 10 {
+		t.Errorf("expected Prev(1) with wrap = 10, got %d", l)
+	}
+}
+
+func TestSelectionPersistence(t *testing.T) {
+	c := newTestCtx()
+	c.config.SelectionOnQueryChange = SelectionPersist
+	c.lines = []Match{
+		NewNoMatch("foo", false, 0),
+		NewNoMatch("bar", false, 1),
+		NewNoMatch("baz", false, 2),
+	}
+	c.SelectLine(3)
+
+	// "baz" is now the first line, and stays selected
+	c.current = []Match{c.lines[2], c.lines[1]}
+	if !c.IsLineSelected(1) || c.IsLineSelected(2) {
+		t.Errorf("expected the selection to follow the line")
+	}
+
+	// "baz" is no longer displayed, but is still part of the result
+	c.current = []Match{c.lines[0]}
+	c.SelectLine(1)
+	matches := c.TargetMatches()
+	if len(matches) != 2 || matches[0].Index() != 0 || matches[1].Index() != 2 {
+		t.Errorf("expected hidden selected lines to be retained, got %v", matches)
+	}
+
+	c.config.SelectionOnQueryChange = SelectionClear
+	c.SetQuery([]rune("ba"))
+	c.queryChanged()
+	if c.selection.Len() != 0 {
+		t.Errorf("expected the selection to be cleared when the query changes")
+	}
+}
+
+func TestRefineToSelection(t *testing.T) {
+	c := newTestCtx()
+	if c.RefineToSelection() {
+		t.Errorf("expected nothing to refine to without a selection")
+	}
+
+	for n, l := range []string{"foo", "bar", "baz", "qux"} {
+		c.lines = append(c.lines, NewNoMatch(l, false, n))
+	}
+	c.query = []rune("ba")
+	c.selection.Add(3)
+	c.selection.Add(1)
+	if !c.RefineToSelection() {
+		t.Fatalf("expected the buffer to be refined")
+	}
+
+	if len(c.lines) != 2 || c.lines[0].Line() != "bar" || c.lines[1].Line() != "qux" {
+		t.Fatalf("expected the buffer to be the selected lines, got %v", c.lines)
+	}
+	if c.lines[1].Index() != 3 {
+		t.Errorf("expected the lines to keep their positions, got %d", c.lines[1].Index())
+	}
+	if c.selection.Len() != 0 || len(c.query) != 0 {
+		t.Errorf("expected the selection and the query to be reset")
+	}
+
+	if !c.PopBuffer() {
+		t.Fatalf("expected the previous buffer to be restored")
+	}
+	if len(c.lines) != 4 || string(c.query) != "ba" || !reflect.DeepEqual(c.selection, Selection{1, 3}) {
+		t.Errorf("expected the buffer, the query and the selection to be restored")
+	}
+}
+
+func TestSelectionBookmarks(t *testing.T) {
+	c := newTestCtx()
+	c.selection = Selection{1, 2, 3}
+	c.BookmarkSelection("a")
+
+	// The bookmark is not affected by changes to the selection
+	c.selection.Remove(1)
+	c.selection.Add(5)
+
+	tests := []struct {
+		op       func(Selection, Selection) Selection
+		expected Selection
+	}{
+		{Selection.Difference, Selection{5}},
+		{Selection.Union, Selection{1, 2, 3, 5}},
+		{Selection.Intersection, Selection{2, 3}},
+	}
+	for _, test := range tests {
+		s := c.selection
+		if !c.combineBookmark("a", test.op) {
+			t.Fatalf("expected bookmark 'a' to exist")
+		}
+		if !reflect.DeepEqual(c.selection, test.expected) {
+			t.Errorf("expected selection to be %v, got %v", test.expected, c.selection)
+		}
+		c.selection = s
+	}
+
+	if c.combineBookmark("b", Selection.Union) {
+		t.Errorf("expected bookmark 'b' to not exist")
+	}
+}
+
+func TestSelectSimilar(t *testing.T) {
+	c := newTestCtx()
+	for n, l := range []string{"src/a.go", "src/b.go", "doc/a.md", "src.go"} {
+		c.lines = append(c.lines, NewNoMatch(l, false, n))
+	}
+	c.config.ColumnDelimiter = "/"
+
+	c.currentLine = 1
+	if n, err := c.SelectSimilar(); err != nil || n != 2 {
+		t.Errorf("expected 2 lines to be selected, got %d (%v)", n, err)
+	}
+	if !reflect.DeepEqual(c.selection, Selection{0, 1}) {
+		t.Errorf("expected the lines in src to be selected, got %v", c.selection)
+	}
+
+	c.selection.Clear()
+	c.config.SimilarLines.Pattern = `^{1}\.`
+	c.currentLine = 2
+	if n, err := c.SelectSimilar(); err != nil || n != 2 {
+		t.Errorf("expected 2 lines to be selected, got %d (%v)", n, err)
+	}
+	if !reflect.DeepEqual(c.selection, Selection{1, 3}) {
+		t.Errorf("expected the current line and src.go to be selected, got %v", c.selection)
+	}
+
+	c.config.SimilarLines.Pattern = "("
+	if _, err := c.SelectSimilar(); err == nil {
+		t.Errorf("expected an invalid pattern to be rejected")
+	}
+}
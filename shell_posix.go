@@ -0,0 +1,24 @@
+// +build !windows
+
+package peco
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// shellCommand creates a command that runs `cmd` through the
+// user's shell
+func shellCommand(cmd string) *exec.Cmd {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return exec.Command(shell, "-c", cmd)
+}
+
+// shellQuote quotes `s` so that it is passed as a single argument
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
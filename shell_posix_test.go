@@ -0,0 +1,34 @@
+// +build !windows
+
+package peco
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"foo", `'foo'`},
+		{"a b", `'a b'`},
+		{"", `''`},
+		{"it's", `'it'\''s'`},
+		{`say "hi"`, `'say "hi"'`},
+		{"$HOME `id`", "'$HOME `id`'"},
+	}
+	for _, test := range tests {
+		if s := shellQuote(test.s); s != test.expected {
+			t.Errorf("expected %q to be quoted as %q, got %q", test.s, test.expected, s)
+		}
+
+		// The shell takes the quoted string as it is
+		out, err := shellCommand("printf %s " + shellQuote(test.s)).Output()
+		if err != nil {
+			t.Errorf("failed to run the shell with %q: %s", test.s, err)
+			continue
+		}
+		if string(out) != test.s {
+			t.Errorf("expected the shell to print %q, got %q", test.s, string(out))
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package peco
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// shellCommand creates a command that runs `cmd` through cmd.exe
+func shellCommand(cmd string) *exec.Cmd {
+	return exec.Command("cmd", "/c", cmd)
+}
+
+// shellQuote quotes `s` so that it is passed as a single argument
+func shellQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,23 @@
+// +build windows
+
+package peco
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"foo", `"foo"`},
+		{"a b", `"a b"`},
+		{"", `""`},
+		{"it's", `"it's"`},
+		{`say "hi"`, `"say ""hi"""`},
+	}
+	for _, test := range tests {
+		if s := shellQuote(test.s); s != test.expected {
+			t.Errorf("expected %q to be quoted as %q, got %q", test.s, test.expected, s)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package peco
+
+import (
+	"strconv"
+	"strings"
+)
+
+// splitColumns splits `line` into columns separated by `delim`. If
+// `delim` is empty, columns are separated by whitespace
+func splitColumns(line, delim string) []string {
+	if delim == "" {
+		return strings.Fields(line)
+	}
+	return strings.Split(line, delim)
+}
+
+// delimiterSampleSize is the number of lines that AutoDelimiter
+// looks at to detect the delimiter
+const delimiterSampleSize = 20
+
+// delimiterCandidates are the delimiters that AutoDelimiter looks for,
+// in the order of preference. If none of them are used consistently,
+// columns are separated by whitespace
+var delimiterCandidates = []string{"\t", ",", ";", "|"}
+
+// detectDelimiter returns the delimiter that appears the same number
+// of times in each of `lines`, or an empty string (i.e. whitespace) if
+// there's no such delimiter
+func detectDelimiter(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+CANDIDATES:
+	for _, delim := range delimiterCandidates {
+		count := strings.Count(lines[0], delim)
+		if count == 0 {
+			continue
+		}
+		for _, line := range lines[1:] {
+			if strings.Count(line, delim) != count {
+				continue CANDIDATES
+			}
+		}
+		return delim
+	}
+	return ""
+}
+
+// delimiterName returns the name of `delim` as displayed in the status line
+func delimiterName(delim string) string {
+	switch delim {
+	case "":
+		return "space"
+	case "\t":
+		return "tab"
+	case ",":
+		return "comma"
+	}
+	return delim
+}
+
+// columnDelimiter returns the delimiter that separates the columns.
+// ColumnDelimiter takes precedence, and otherwise, if AutoDelimiter is
+// enabled, the delimiter detected from the first lines of the input.
+// Until they have been read, columns are separated by whitespace
+func (c *Ctx) columnDelimiter() string {
+	if c.config.ColumnDelimiter != "" || !c.config.AutoDelimiter {
+		return c.config.ColumnDelimiter
+	}
+
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	return c.detectedDelimiter
+}
+
+// setDetectedDelimiter detects the delimiter from `sample`, the first
+// lines of the input. It's called once by the reader, after
+// delimiterSampleSize lines or the end of the input
+func (c *Ctx) setDetectedDelimiter(sample []string) {
+	delim := detectDelimiter(sample)
+
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	c.detectedDelimiter = delim
+}
+
+// columnSorter sorts lines by the value of one of their columns.
+// Values that look like numbers are compared numerically
+type columnSorter struct {
+	matches    []Match
+	keys       []string
+	descending bool
+}
+
+func (c *Ctx) newColumnSorter(matches []Match) columnSorter {
+	keys := make([]string, len(matches))
+	delim := c.columnDelimiter()
+	for n, m := range matches {
+		cols := splitColumns(m.Line(), delim)
+		if c.sortColumn <= len(cols) {
+			keys[n] = strings.TrimSpace(cols[c.sortColumn-1])
+		}
+	}
+	return columnSorter{matches, keys, c.sortDescending}
+}
+
+func (s columnSorter) Len() int {
+	return len(s.matches)
+}
+
+func (s columnSorter) Swap(i, j int) {
+	s.matches[i], s.matches[j] = s.matches[j], s.matches[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s columnSorter) Less(i, j int) bool {
+	if s.descending {
+		return compareColumns(s.keys[j], s.keys[i]) < 0
+	}
+	return compareColumns(s.keys[i], s.keys[j]) < 0
+}
+
+// These are the values for Config.TieBreak
+const (
+	// TieBreakIndex keeps the lines in the order of the input
+	TieBreakIndex = "index"
+	// TieBreakLength displays shorter lines first
+	TieBreakLength = "length"
+	// TieBreakLexical displays the lines in alphabetical order
+	TieBreakLexical = "lexical"
+)
+
+// tieBreaker orders lines that are otherwise equal, according to
+// one of the TieBreak policies
+type tieBreaker struct {
+	matches []Match
+	policy  string
+}
+
+func (s tieBreaker) Len() int {
+	return len(s.matches)
+}
+
+func (s tieBreaker) Swap(i, j int) {
+	s.matches[i], s.matches[j] = s.matches[j], s.matches[i]
+}
+
+func (s tieBreaker) Less(i, j int) bool {
+	a, b := s.matches[i], s.matches[j]
+	switch s.policy {
+	case TieBreakLength:
+		return len(a.Line()) < len(b.Line())
+	case TieBreakLexical:
+		return a.Line() < b.Line()
+	}
+	return a.Index() < b.Index()
+}
+
+// compareColumns compares two column values. Numbers are compared
+// numerically, and are sorted before anything else
+func compareColumns(a, b string) int {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil:
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
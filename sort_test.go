@@ -0,0 +1,121 @@
+package peco
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSortByColumn(t *testing.T) {
+	c := newTestCtx()
+	c.config.ColumnDelimiter = ","
+	matches := []Match{
+		NewNoMatch("c,10", false, 0),
+		NewNoMatch("a,9", false, 1),
+		NewNoMatch("b,100", false, 2),
+		NewNoMatch("d,9", false, 3),
+	}
+
+	check := func(expected ...int) {
+		ordered := c.orderMatches(matches)
+		for n, index := range expected {
+			if ordered[n].Index() != index {
+				t.Errorf("expected line %d (column %d, descending = %t) to be %d, got %d", n, c.sortColumn, c.sortDescending, index, ordered[n].Index())
+			}
+		}
+	}
+
+	c.sortColumn = 1
+	check(1, 2, 0, 3)
+
+	// numeric, and stable
+	c.sortColumn = 2
+	check(1, 3, 0, 2)
+
+	c.sortDescending = true
+	check(2, 0, 1, 3)
+}
+
+func TestTieBreak(t *testing.T) {
+	c := newTestCtx()
+	c.config.ColumnDelimiter = ","
+	matches := []Match{
+		NewNoMatch("1,ccc", false, 0),
+		NewNoMatch("2,bbbb", false, 1),
+		NewNoMatch("1,aaaa", false, 2),
+		NewNoMatch("1,dd", false, 3),
+	}
+
+	check := func(expected ...int) {
+		ordered := c.orderMatches(matches)
+		for n, index := range expected {
+			if ordered[n].Index() != index {
+				t.Errorf("expected line %d (tie break = %s) to be %d, got %d", n, c.config.TieBreak, index, ordered[n].Index())
+			}
+		}
+	}
+
+	check(0, 1, 2, 3)
+
+	c.config.TieBreak = TieBreakLength
+	check(3, 0, 1, 2)
+
+	c.config.TieBreak = TieBreakLexical
+	check(2, 0, 3, 1)
+
+	// Only lines with the same value in the sort column are reordered
+	c.config.TieBreak = TieBreakLength
+	c.sortColumn = 1
+	check(3, 0, 2, 1)
+}
+
+func TestDetectDelimiter(t *testing.T) {
+	tests := []struct {
+		lines    []string
+		expected string
+	}{
+		{[]string{"a\tb\tc", "d\te\tf"}, "\t"},
+		{[]string{"a,b", "c,d", "e,f"}, ","},
+		{[]string{"a,b c", "c d", "e,f"}, ""}, // inconsistent commas
+		{[]string{"a,b\tc", "d,e\tf"}, "\t"},  // tabs are preferred
+		{[]string{"foo bar", "baz qux"}, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		if delim := detectDelimiter(test.lines); delim != test.expected {
+			t.Errorf("expected delimiter for %q to be %q, got %q", test.lines, test.expected, delim)
+		}
+	}
+
+	read := func(input string) *Ctx {
+		c := newTestCtx()
+		go func() {
+			for range c.DrawCh() {
+			}
+		}()
+		c.config.AutoDelimiter = true
+		r := c.NewBufferReader(ioutil.NopCloser(strings.NewReader(input)))
+		go func() {
+			for range r.InputReadyCh() {
+			}
+		}()
+		c.AddWaitGroup(1)
+		r.Loop()
+		return c
+	}
+
+	c := read("a;1\nb;2\n")
+	if delim := c.columnDelimiter(); delim != ";" {
+		t.Errorf("expected the delimiter to be detected, got %q", delim)
+	}
+
+	// Only the first delimiterSampleSize lines are looked at
+	c = read(strings.Repeat("a,1\n", delimiterSampleSize) + "b 2\n")
+	if delim := c.columnDelimiter(); delim != "," {
+		t.Errorf("expected the delimiter to be detected from the first lines, got %q", delim)
+	}
+	c.config.ColumnDelimiter = ","
+	if delim := c.columnDelimiter(); delim != "," {
+		t.Errorf("expected ColumnDelimiter to take precedence, got %q", delim)
+	}
+}
@@ -0,0 +1,65 @@
+package peco
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+)
+
+// sourceCommand is the output of the command that produces the input
+// (see StartSourceCommand)
+type sourceCommand struct {
+	io.ReadCloser
+	ctx    *Ctx
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+	// eof is set once the output has been read to the end. It's
+	// protected by mutex, as Close may be called while reading
+	eof   bool
+	mutex sync.Mutex
+}
+
+// StartSourceCommand starts `command` using the shell, and returns its
+// output to be read as the input. What it writes to stderr is kept, so
+// that it can be displayed if there are no lines (see OnEmptyInput)
+func (c *Ctx) StartSourceCommand(command string) (io.ReadCloser, error) {
+	cmd := shellCommand(command)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error: Failed to run '%s': %s", command, err)
+	}
+	return &sourceCommand{ReadCloser: stdout, ctx: c, cmd: cmd, stderr: stderr}, nil
+}
+
+func (s *sourceCommand) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err == io.EOF {
+		s.mutex.Lock()
+		s.eof = true
+		s.mutex.Unlock()
+	}
+	return n, err
+}
+
+// Close waits for the command to exit, killing it if its output hasn't
+// been read to the end (i.e. peco is exiting), and keeps its stderr
+func (s *sourceCommand) Close() error {
+	s.mutex.Lock()
+	if !s.eof {
+		s.cmd.Process.Kill()
+	}
+	s.mutex.Unlock()
+	err := s.cmd.Wait()
+
+	s.ctx.bufferMutex.Lock()
+	defer s.ctx.bufferMutex.Unlock()
+	s.ctx.sourceStderr = s.stderr.String()
+	return err
+}
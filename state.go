@@ -0,0 +1,173 @@
+package peco
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// viewState is what is remembered about a source between invocations
+// of peco (see StateConfig)
+type viewState struct {
+	Query       string `json:"Query"`
+	CurrentLine int    `json:"CurrentLine"`
+	// Updated is when the state was last saved, in seconds since the
+	// epoch. It's used to evict the least recently used sources
+	Updated int64 `json:"Updated"`
+}
+
+// _stateNow returns the time that a state is saved at. Tests replace
+// it, as states saved within the same second can't be told apart
+var _stateNow = time.Now
+
+// SetStateKey sets the key that identifies the source of the input in
+// the state file (see --state-key)
+func (c *Ctx) SetStateKey(key string) {
+	c.stateKey = key
+}
+
+// stateFile returns the file that the state is saved to
+func (c *Ctx) stateFile() (string, error) {
+	if file := c.config.State.File; file != "" {
+		return file, nil
+	}
+
+	home, err := homedirFunc()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".peco", "state.json"), nil
+}
+
+// readStates reads the states from `file`. A file that doesn't exist
+// yet is the same as an empty one
+func readStates(file string) (map[string]viewState, error) {
+	states := map[string]viewState{}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &states); err != nil {
+		return nil, fmt.Errorf("error: Failed to parse %s: %s", file, err)
+	}
+	return states, nil
+}
+
+// evictStates removes the least recently updated states, so that at
+// most `max` of them are left. The state of `current` is always kept,
+// as the others may have been updated within the same second. Nothing
+// is removed if `max` is 0
+func evictStates(states map[string]viewState, max int, current string) {
+	if max <= 0 || len(states) <= max {
+		return
+	}
+
+	keys := statesByAge{states, make([]string, 0, len(states))}
+	for key := range states {
+		if key != current {
+			keys.keys = append(keys.keys, key)
+		}
+	}
+	if _, ok := states[current]; ok {
+		max--
+	}
+
+	sort.Sort(keys)
+	for _, key := range keys.keys[max:] {
+		delete(states, key)
+	}
+}
+
+// statesByAge sorts the keys of states, most recently updated first
+type statesByAge struct {
+	states map[string]viewState
+	keys   []string
+}
+
+func (s statesByAge) Len() int {
+	return len(s.keys)
+}
+
+func (s statesByAge) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
+
+func (s statesByAge) Less(i, j int) bool {
+	return s.states[s.keys[i]].Updated > s.states[s.keys[j]].Updated
+}
+
+// RestoreState restores the query and the current line from the last
+// time peco was run on the same source. Returns true if a query was
+// restored, in which case ExecQuery needs to be called
+func (c *Ctx) RestoreState() bool {
+	if !c.config.State.Enable || c.stateKey == "" {
+		return false
+	}
+
+	file, err := c.stateFile()
+	if err != nil {
+		return false
+	}
+	states, err := readStates(file)
+	if err != nil {
+		c.logger.logf(LogError, "state", "file", file, "error", err)
+		return false
+	}
+
+	s, ok := states[c.stateKey]
+	if !ok {
+		return false
+	}
+	if s.CurrentLine > 0 {
+		c.currentLine = s.CurrentLine
+	}
+	c.SetQuery([]rune(s.Query))
+	return s.Query != ""
+}
+
+// SaveState saves the query and the current line of the original
+// buffer, so that they can be restored by RestoreState
+func (c *Ctx) SaveState() error {
+	if !c.config.State.Enable || c.stateKey == "" {
+		return nil
+	}
+
+	file, err := c.stateFile()
+	if err != nil {
+		return err
+	}
+	states, err := readStates(file)
+	if err != nil {
+		return err
+	}
+
+	query, currentLine := c.query, c.currentLine
+	c.bufferMutex.Lock()
+	if len(c.frames) > 0 {
+		query, currentLine = c.frames[0].query, c.frames[0].currentLine
+	}
+	c.bufferMutex.Unlock()
+	states[c.stateKey] = viewState{
+		Query:       string(query),
+		CurrentLine: currentLine,
+		Updated:     _stateNow().Unix(),
+	}
+	evictStates(states, c.config.State.MaxKeys, c.stateKey)
+
+	buf, err := json.MarshalIndent(states, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf, 0644)
+}
@@ -0,0 +1,81 @@
+package peco
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveRestoreState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peco-state")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1000, 0)
+	_stateNow = func() time.Time { return now }
+	defer func() { _stateNow = time.Now }()
+
+	c := newTestCtx()
+	c.config.State = StateConfig{Enable: true, File: filepath.Join(dir, "state.json"), MaxKeys: 2}
+	for n, key := range []string{"a", "b", "c"} {
+		now = now.Add(time.Second)
+		c.SetStateKey(key)
+		c.SetQuery([]rune(key))
+		c.currentLine = n + 1
+		if err := c.SaveState(); err != nil {
+			t.Fatalf("Failed to save state: %s", err)
+		}
+	}
+
+	states, err := readStates(c.config.State.File)
+	if err != nil {
+		t.Fatalf("Failed to read states: %s", err)
+	}
+	if _, ok := states["a"]; ok || len(states) != 2 {
+		t.Errorf("expected the state of 'a' to be evicted, got %#v", states)
+	}
+	if states["c"].Updated != 1003 {
+		t.Errorf("expected 'c' to be saved at 1003, got %d", states["c"].Updated)
+	}
+
+	c = newTestCtx()
+	c.config.State = StateConfig{Enable: true, File: filepath.Join(dir, "state.json"), MaxKeys: 2}
+	c.SetStateKey("c")
+	if !c.RestoreState() {
+		t.Fatalf("expected the query to be restored")
+	}
+	if string(c.query) != "c" || c.currentLine != 3 {
+		t.Errorf("expected query 'c' and line 3, got '%s' and %d", string(c.query), c.currentLine)
+	}
+
+	c.SetStateKey("missing")
+	if c.RestoreState() {
+		t.Errorf("expected nothing to be restored for an unknown key")
+	}
+}
+
+func TestEvictStates(t *testing.T) {
+	states := map[string]viewState{
+		"old":    {Updated: 1},
+		"new":    {Updated: 3},
+		"middle": {Updated: 2},
+	}
+	evictStates(states, 2, "")
+	if _, ok := states["old"]; ok || len(states) != 2 {
+		t.Errorf("expected the oldest state to be evicted, got %#v", states)
+	}
+
+	evictStates(states, 1, "middle")
+	if _, ok := states["middle"]; !ok || len(states) != 1 {
+		t.Errorf("expected the current state to be kept, got %#v", states)
+	}
+
+	evictStates(states, 0, "")
+	if len(states) != 1 {
+		t.Errorf("expected nothing to be evicted without a limit, got %#v", states)
+	}
+}
@@ -13,6 +13,10 @@ import (
 type View struct {
 	*Ctx
 	clearTimer *time.Timer
+	cache      *lineCache
+	// statusMsg is the status message in compact mode, where it's
+	// displayed in the query line for lack of a status line
+	statusMsg string
 }
 
 // PagingRequest can be sent to move the selection cursor
@@ -67,7 +71,20 @@ func (v *View) printStatus(msg string) {
 		t.Stop()
 	}
 
-	w, h := termbox.Size()
+	// There's no status line in compact mode, so the message is
+	// displayed in the query line instead
+	if v.compact {
+		v.mutex.Lock()
+		changed := v.statusMsg != msg
+		v.statusMsg = msg
+		v.mutex.Unlock()
+		if changed {
+			v.drawScreen(nil)
+		}
+		return
+	}
+
+	w, h := v.size()
 
 	width := runewidth.StringWidth(msg)
 	for width > w {
@@ -88,16 +105,16 @@ func (v *View) printStatus(msg string) {
 	bgAttr := v.config.Style.Basic.bg
 
 	if w > width {
-		printTB(0, h-2, fgAttr, bgAttr, string(pad))
+		v.printTB(0, h-2, fgAttr, bgAttr, string(pad))
 	}
 
 	if width > 0 {
-		printTB(w-width, h-2, fgAttr|termbox.AttrReverse|termbox.AttrBold, bgAttr|termbox.AttrReverse, msg)
+		v.printTB(w-width, h-2, fgAttr|termbox.AttrReverse|termbox.AttrBold, bgAttr|termbox.AttrReverse, msg)
 	}
 	termbox.Flush()
 }
 
-func printTB(x, y int, fg, bg termbox.Attribute, msg string) {
+func (v *View) printTB(x, y int, fg, bg termbox.Attribute, msg string) {
 	for len(msg) > 0 {
 		c, w := utf8.DecodeRuneInString(msg)
 		if c == utf8.RuneError {
@@ -105,19 +122,380 @@ func printTB(x, y int, fg, bg termbox.Attribute, msg string) {
 			w = 1
 		}
 		msg = msg[w:]
-		termbox.SetCell(x, y, c, fg, bg)
+		v.setCell(x, y, c, fg, bg)
 		x += runewidth.RuneWidth(c)
 	}
 
-	width, _ := termbox.Size()
+	width, _ := v.size()
+	for ; x < width; x++ {
+		v.setCell(x, y, ' ', fg, bg)
+	}
+}
+
+// borderWidth returns the thickness of the border around the screen
+func (v *View) borderWidth() int {
+	if v.config.Border.Enable {
+		return 1
+	}
+	return 0
+}
+
+// size returns the size of the area that peco draws in, which is the
+// entire screen except for the border
+func (v *View) size() (int, int) {
+	w, h := termbox.Size()
+	b := v.borderWidth()
+	return w - 2*b, h - 2*b
+}
+
+// setCell sets the cell at (x, y) of the area returned by size. Cells
+// outside of the area are ignored, so that the border is kept intact
+func (v *View) setCell(x, y int, ch rune, fg, bg termbox.Attribute) {
+	w, h := v.size()
+	if x < 0 || y < 0 || x >= w || y >= h {
+		return
+	}
+	b := v.borderWidth()
+	termbox.SetCell(x+b, y+b, ch, fg, bg)
+}
+
+// drawBorder draws the border around the screen, if it's enabled
+func (v *View) drawBorder() {
+	if !v.config.Border.Enable {
+		return
+	}
+
+	chars := []rune(v.config.Border.Chars)
+	fg := v.config.Style.Border.fg
+	bg := v.config.Style.Border.bg
+	w, h := termbox.Size()
+	for x := 1; x < w-1; x++ {
+		termbox.SetCell(x, 0, chars[0], fg, bg)
+		termbox.SetCell(x, h-1, chars[0], fg, bg)
+	}
+	for y := 1; y < h-1; y++ {
+		termbox.SetCell(0, y, chars[1], fg, bg)
+		termbox.SetCell(w-1, y, chars[1], fg, bg)
+	}
+	termbox.SetCell(0, 0, chars[2], fg, bg)
+	termbox.SetCell(w-1, 0, chars[3], fg, bg)
+	termbox.SetCell(0, h-1, chars[4], fg, bg)
+	termbox.SetCell(w-1, h-1, chars[5], fg, bg)
+}
+
+// listArea returns the number of rows between the query line and the
+// footer. In compact mode, there's no footer unless the output preview
+// is displayed
+func (v *View) listArea() int {
+	_, height := v.size()
+	if v.compact {
+		rows := height - 1
+		if v.showOutputPreview {
+			rows--
+		}
+		return rows
+	}
+
+	rows := height - 4
+	if v.showOutputPreview {
+		// Leave a row between the lines and the footer
+		rows--
+	}
+	return rows
+}
+
+// outputPreviewRow returns the row that the output preview is drawn on
+func (v *View) outputPreviewRow() int {
+	_, height := v.size()
+	if v.compact {
+		return height - 1
+	}
+	return height - 3
+}
+
+// listRows returns the number of rows available for the lines. When
+// the preview pane is displayed, it takes up the lower half
+func (v *View) listRows() int {
+	rows := v.listArea()
+	if v.showPreview {
+		rows /= 2
+	}
+	return rows
+}
+
+// perPage returns the number of lines that fit in the screen. When the
+// info line is displayed, each line takes up two rows
+func (v *View) perPage() int {
+	rows := v.listRows()
+	if v.showInfoLine {
+		rows /= 2
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return rows
+}
+
+func (v *View) drawLine(x, y, width int, r *renderedLine, fg, bg termbox.Attribute) {
+	// Find where the trailing whitespace starts, if it's to be shown
+	trailing := len(r.cells)
+	if v.config.ShowWhitespace {
+		for trailing > 0 && isBlank(r.cells[trailing-1].ch) {
+			trailing--
+		}
+	}
+
+	flashing := time.Now().Before(v.flashUntil)
+	for n, cell := range r.cells {
+		ch := cell.ch
+		if ch == '\t' {
+			ch = ' '
+		}
+
+		switch {
+		case cell.term >= 0 && flashing:
+			style := v.config.Style.Flash
+			v.setCell(x+cell.x, y, ch, style.fg, style.bg)
+		case cell.term >= 0 && v.config.UnderlineMatches:
+			// Keep the style of the line, and just mark the match
+			v.setCell(x+cell.x, y, ch, fg|termbox.AttrUnderline, bg)
+		case cell.term >= 0:
+			style := v.config.Style.MatchedTerm(cell.term)
+			v.setCell(x+cell.x, y, ch, style.fg, bg|style.bg)
+		case v.config.ShowWhitespace && (cell.ch == '\t' || n >= trailing):
+			style := v.config.Style.Whitespace
+			v.setCell(x+cell.x, y, whitespaceMarker(cell.ch), style.fg, bg|style.bg)
+		default:
+			v.setCell(x+cell.x, y, ch, fg, bg)
+		}
+	}
+
+	x += r.width
+
+	// Unless the info line is displayed, the description follows
+	// the line itself
+	if d := r.desc; d != nil && !v.showInfoLine {
+		x += 2
+		v.drawDescription(x, y, d, bg)
+		x += d.width
+	}
+
+	v.drawPadding(x, y, width, fg, bg)
+}
+
+// drawPadding fills the rest of the row from `x`. The style of the line
+// is only used if HighlightFullLine is enabled
+func (v *View) drawPadding(x, y, width int, fg, bg termbox.Attribute) {
+	if !v.config.HighlightFullLine {
+		fg = v.config.Style.Basic.fg
+		bg = v.config.Style.Basic.bg
+	}
 	for ; x < width; x++ {
-		termbox.SetCell(x, y, ' ', fg, bg)
+		v.setCell(x, y, ' ', fg, bg)
+	}
+}
+
+func isBlank(c rune) bool {
+	return c == ' ' || c == '\t'
+}
+
+// whitespaceMarker returns the character that is displayed in place
+// of a whitespace character when ShowWhitespace is enabled
+func whitespaceMarker(c rune) rune {
+	if c == '\t' {
+		return '»'
+	}
+	return '·'
+}
+
+// drawDescription draws the description of a line using the
+// Description style, over the background of the line
+func (v *View) drawDescription(x, y int, d *renderedLine, bg termbox.Attribute) {
+	style := v.config.Style.Description
+	for _, cell := range d.cells {
+		if cell.term >= 0 {
+			matched := v.config.Style.MatchedTerm(cell.term)
+			v.setCell(x+cell.x, y, cell.ch, matched.fg, bg|matched.bg)
+			continue
+		}
+		v.setCell(x+cell.x, y, cell.ch, style.fg, bg|style.bg)
+	}
+}
+
+// drawInfoLine draws the description of a line on the row below it
+func (v *View) drawInfoLine(x, y, width int, r *renderedLine, fg, bg termbox.Attribute) {
+	for i := 0; i < x+2; i++ {
+		v.setCell(i, y, ' ', fg, bg)
+	}
+	x += 2
+	if d := r.desc; d != nil {
+		v.drawDescription(x, y, d, bg)
+		x += d.width
+	}
+	v.drawPadding(x, y, width, fg, bg)
+}
+
+// drawLineNumber draws the line number column for the line at `lineno`,
+// and returns the x position where the line itself should be drawn.
+// Numbers are either the position in the original input, or the
+// distance from the current line if relative numbers are enabled
+func (v *View) drawLineNumber(y, width, lineno int, target Match, fg, bg termbox.Attribute) int {
+	number := target.Index() + 1
+	if v.relativeNumbers {
+		number = lineno - v.currentLine
+		if number < 0 {
+			number = -number
+		}
+	}
+
+	v.printTB(0, y, fg, bg, fmt.Sprintf("%*d ", width, number))
+	return width + 1
+}
+
+// displayQuery returns the query as it should be displayed. If
+// QueryMask is set, every character is replaced by the mask
+func (v *View) displayQuery() []rune {
+	mask := []rune(v.config.QueryMask)
+	if len(mask) == 0 || v.IsCommandMode() {
+		return v.query
+	}
+
+	masked := make([]rune, len(v.query))
+	for i := range masked {
+		masked[i] = mask[0]
+	}
+	return masked
+}
+
+// drawQuery draws the query and the caret in `width` cells from `x`.
+// If QueryScroll is enabled, the query is scrolled so that the caret
+// stays visible. Otherwise whatever doesn't fit is cut off
+func (v *View) drawQuery(x, width int) {
+	query := v.displayQuery()
+	if v.config.QueryScroll.Enable {
+		v.queryOffset = scrollQuery(query, v.caretPos, v.queryOffset, width, v.config.QueryScroll.Margin)
+	} else {
+		v.queryOffset = 0
+	}
+
+	end := x + width
+	for i := v.queryOffset; i <= len(query); i++ {
+		r := ' ' // the caret after the string
+		if i < len(query) {
+			r = query[i]
+		}
+		w := runewidth.RuneWidth(r)
+		if x+w > end {
+			break
+		}
+
+		fg := v.config.Style.Query.fg
+		bg := v.config.Style.Query.bg
+		if i == v.caretPos {
+			fg |= termbox.AttrReverse
+			bg |= termbox.AttrReverse
+		} else if i == len(query) {
+			break
+		}
+		v.setCell(x, 0, r, fg, bg)
+		x += w
+	}
+}
+
+// scrollQuery returns the first character of `query` to display in
+// `width` cells, so that the caret at `caretPos` is visible along with
+// `margin` cells on each side of it. `offset` is the previous one,
+// which is kept for as long as it satisfies these
+func scrollQuery(query []rune, caretPos, offset, width, margin int) int {
+	if 2*margin >= width {
+		margin = (width - 1) / 2
+	}
+	cells := func(rs []rune) int {
+		return runewidth.StringWidth(string(rs))
+	}
+
+	if offset > caretPos {
+		offset = caretPos
+	}
+	// don't leave space at the end while there's more on the left.
+	// The caret after the string takes 1 cell
+	for offset > 0 && cells(query[offset-1:])+1 <= width {
+		offset--
+	}
+	for offset > 0 && cells(query[offset:caretPos]) < margin {
+		offset--
+	}
+
+	caretWidth, after := 1, 0
+	if caretPos < len(query) {
+		caretWidth = runewidth.RuneWidth(query[caretPos])
+		after = cells(query[caretPos+1:])
+	}
+	if after > margin {
+		after = margin
+	}
+	for offset < caretPos && cells(query[offset:caretPos])+caretWidth+after > width {
+		offset++
+	}
+	return offset
+}
+
+// drawPreview draws the preview of the current line below the lines
+func (v *View) drawPreview(width, height int, targets []Match) {
+	top := v.listRows() + 1
+	bottom := v.listArea()
+
+	fg := v.config.Style.Basic.fg
+	bg := v.config.Style.Basic.bg
+	// The separator is highlighted while the preview has the focus
+	sep := v.config.Style.Basic
+	if v.previewFocused {
+		sep = v.config.Style.Selected
+	}
+	for x := 0; x < width; x++ {
+		v.setCell(x, top, '─', sep.fg, sep.bg)
+	}
+
+	var preview string
+	lineno := 0
+	if v.currentLine >= 1 && v.currentLine <= len(targets) {
+		m := targets[v.currentLine-1]
+		preview, _ = v.Preview(m)
+		if v.previewToMatch {
+			lineno, _ = locationLineNumber(m.Output())
+		}
+	}
+
+	lines := previewLines(preview)
+	offset := previewOffset(lineno, bottom-top, len(lines))
+	if v.previewScroll != 0 {
+		scrolled := clampPreviewOffset(offset+v.previewScroll, bottom-top, len(lines))
+		// Don't let it scroll further than there are lines
+		v.previewScroll = scrolled - offset
+		offset = scrolled
+	}
+	for y := top + 1; y <= bottom && offset+y-top-1 < len(lines); y++ {
+		n := offset + y - top - 1
+		if n == lineno-1 {
+			style := v.config.Style.Selected
+			v.printTB(0, y, style.fg, style.bg, lines[n])
+			continue
+		}
+		v.printTB(0, y, fg, bg, lines[n])
 	}
 }
 
 func (v *View) movePage(p PagingRequest) {
-	_, height := termbox.Size()
-	perPage := height - 4
+	if v.previewFocused {
+		v.scrollPreview(p)
+		v.drawScreen(nil)
+		return
+	}
+	// The preview of the new line is displayed from where it
+	// normally starts
+	v.previewScroll = 0
+
+	perPage := v.perPage()
 
 	switch p {
 	case ToPrevLine:
@@ -145,6 +523,26 @@ func (v *View) movePage(p PagingRequest) {
 	v.drawScreen(nil)
 }
 
+// scrollPreview scrolls the preview pane by a line or a page, instead
+// of moving the cursor in the list
+func (v *View) scrollPreview(p PagingRequest) {
+	rows := v.listArea() - v.listRows() - 1
+	if rows < 1 {
+		rows = 1
+	}
+
+	switch p {
+	case ToPrevLine:
+		v.previewScroll--
+	case ToNextLine:
+		v.previewScroll++
+	case ToPrevPage:
+		v.previewScroll -= rows
+	case ToNextPage:
+		v.previewScroll += rows
+	}
+}
+
 func (v *View) drawScreen(targets []Match) {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
@@ -155,20 +553,21 @@ func (v *View) drawScreen(targets []Match) {
 	if err := termbox.Clear(fgAttr, bgAttr); err != nil {
 		return
 	}
+	v.drawBorder()
 
 	if targets == nil {
 		if current := v.Ctx.current; current != nil {
 			targets = v.Ctx.current
 		} else {
-			targets = v.Ctx.lines
+			targets = v.emptyQueryMatches()
 		}
 	}
 	if v.Ctx.currentLine > len(targets) && len(targets) > 0 {
 		v.Ctx.currentLine = len(targets)
 	}
 
-	width, height := termbox.Size()
-	perPage := height - 4
+	width, height := v.size()
+	perPage := v.perPage()
 
 CALCULATE_PAGE:
 	currentPage := &v.Ctx.currentPage
@@ -198,13 +597,26 @@ CALCULATE_PAGE:
 	bgAttr = v.config.Style.Query.bg
 
 	var prompt string
-	if len(v.Ctx.prompt) > 0 {
+	if v.IsCommandMode() {
+		prompt = v.commandMode.Prompt()
+	} else if len(v.Ctx.prompt) > 0 {
 		prompt = string(v.Ctx.prompt)
 	} else {
 		prompt = v.config.Prompt
 	}
+	if !v.IsCommandMode() && v.Matcher().String() == RegexpMatch {
+		prompt = "[.*] " + prompt
+	}
+	if v.compact && !v.IsCommandMode() {
+		prompt = ">"
+	}
 	promptLen := runewidth.StringWidth(prompt)
-	printTB(0, 0, fgAttr, bgAttr, prompt)
+	if time.Now().Before(v.promptFlashUntil) {
+		style := v.config.Style.Flash
+		v.printTB(0, 0, style.fg, style.bg, prompt)
+	} else {
+		v.printTB(0, 0, fgAttr, bgAttr, prompt)
+	}
 
 	if v.caretPos <= 0 {
 		v.caretPos = 0 // sanity
@@ -213,75 +625,129 @@ CALCULATE_PAGE:
 		v.caretPos = len(v.query)
 	}
 
-	if v.caretPos == len(v.query) {
-		// the entire string + the caret after the string
-		printTB(promptLen+1, 0, fgAttr, bgAttr, string(v.query))
-		termbox.SetCell(promptLen+1+runewidth.StringWidth(string(v.query)), 0, ' ', fgAttr|termbox.AttrReverse, bgAttr|termbox.AttrReverse)
-	} else {
-		// the caret is in the middle of the string
-		prev := 0
-		for i, r := range v.query {
-			fg := v.config.Style.Query.fg
-			bg := v.config.Style.Query.bg
-			if i == v.caretPos {
-				fg |= termbox.AttrReverse
-				bg |= termbox.AttrReverse
-			}
-			termbox.SetCell(promptLen+1+prev, 0, r, fg, bg)
-			prev += runewidth.RuneWidth(r)
+	pmsg := fmt.Sprintf("%s [%d/%d]", v.Ctx.Matcher().String(), currentPage.index, maxPage)
+	if v.sortColumn > 0 {
+		direction := "asc"
+		if v.sortDescending {
+			direction = "desc"
 		}
+		pmsg = fmt.Sprintf("[col %d %s] %s", v.sortColumn, direction, pmsg)
+	}
+	if v.config.AutoDelimiter && v.config.ColumnDelimiter == "" {
+		pmsg = fmt.Sprintf("[%s] %s", delimiterName(v.columnDelimiter()), pmsg)
+	}
+	if v.scopeCenter >= 0 {
+		pmsg = fmt.Sprintf("[scope ±%d] %s", v.config.ViewportScopeLines, pmsg)
+	}
+	if v.matchDescription {
+		pmsg = "[desc] " + pmsg
+	}
+	if v.resultsLocked {
+		pmsg = "[locked] " + pmsg
+	}
+	if b := v.Breadcrumb(); b != "" {
+		pmsg = b + " " + pmsg
 	}
 
-	pmsg := fmt.Sprintf("%s [%d/%d]", v.Ctx.Matcher().String(), currentPage.index, maxPage)
+	queryWidth := width - promptLen - 1
+	status, statusFg, statusBg := pmsg, fgAttr, bgAttr
+	if v.compact {
+		// pmsg doesn't fit, but the status message takes its place
+		// using the style of the status line
+		status = v.statusMsg
+		statusFg = fgAttr | termbox.AttrReverse | termbox.AttrBold
+		statusBg = bgAttr | termbox.AttrReverse
+	}
+	if status != "" {
+		statusWidth := runewidth.StringWidth(status)
+		v.printTB(width-statusWidth, 0, statusFg, statusBg, status)
+		if queryWidth-statusWidth-1 > 0 {
+			// keep the query clear of the status
+			queryWidth -= statusWidth + 1
+		}
+	}
+	v.drawQuery(promptLen+1, queryWidth)
 
-	printTB(width-runewidth.StringWidth(pmsg), 0, fgAttr, bgAttr, pmsg)
+	// Lay out the lines in the current page, as well as some lines
+	// above and below it, so that scrolling can reuse them
+	v.cache.Prepare(targets, currentPage.offset-v.config.OverScan, currentPage.offset+perPage+v.config.OverScan)
 
+	numberWidth := len(fmt.Sprintf("%d", v.rootBufferLen()))
 	for n := 1; n <= perPage; n++ {
+		targetIdx := currentPage.offset + n - 1
+		if targetIdx >= len(targets) {
+			break
+		}
+		target := targets[targetIdx]
+
 		fgAttr = v.config.Style.Basic.fg
 		bgAttr = v.config.Style.Basic.bg
 		if n+currentPage.offset == v.currentLine {
 			fgAttr = v.config.Style.Selected.fg
 			bgAttr = v.config.Style.Selected.bg
-		} else if v.selection.Has(n+currentPage.offset) || v.SelectedRange().Has(n+currentPage.offset) {
+		} else if v.selection.Has(target.Index()) || v.SelectedRange().Has(n+currentPage.offset) {
 			fgAttr = v.config.Style.SavedSelection.fg
 			bgAttr = v.config.Style.SavedSelection.bg
+		} else if IsUnmatchedPin(target) {
+			fgAttr = v.config.Style.PinnedUnmatched.fg
+			bgAttr = v.config.Style.PinnedUnmatched.bg
+		} else if v.IsPinned(target) {
+			fgAttr = v.config.Style.Pinned.fg
+			bgAttr = v.config.Style.Pinned.bg
+		} else if style := v.LineStyle(target); style != nil {
+			fgAttr = style.fg
+			bgAttr = style.bg
+		} else if v.IsDirectory(target) {
+			fgAttr = v.config.Style.Directory.fg
+			bgAttr = v.config.Style.Directory.bg
 		}
 
-		targetIdx := currentPage.offset + n - 1
-		if targetIdx >= len(targets) {
-			break
+		y := n
+		if v.showInfoLine {
+			y = 2*n - 1
 		}
 
-		target := targets[targetIdx]
-		line := target.Line()
-		matches := target.Indices()
-		if matches == nil {
-			printTB(0, n, fgAttr, bgAttr, line)
-		} else {
-			prev := 0
-			index := 0
-			for _, m := range matches {
-				if m[0] > index {
-					c := line[index:m[0]]
-					printTB(prev, n, fgAttr, bgAttr, c)
-					prev += runewidth.StringWidth(c)
-					index += len(c)
-				}
-				c := line[m[0]:m[1]]
-				printTB(prev, n, v.config.Style.Matched.fg, bgAttr|v.config.Style.Matched.bg, c)
-				prev += runewidth.StringWidth(c)
-				index += len(c)
+		x := 0
+		if v.config.ShowLineNumbers {
+			x = v.drawLineNumber(y, numberWidth, n+currentPage.offset, target, fgAttr, bgAttr)
+		}
+		r := v.cache.Get(target)
+		v.drawLine(x, y, width, r, fgAttr, bgAttr)
+		if v.showInfoLine {
+			v.drawInfoLine(x, y+1, width, r, fgAttr, bgAttr)
+		}
+	}
+
+	if v.isEmptyQueryLimited() && !v.IsCommandMode() {
+		// Let the user know that there's more than what is displayed
+		if n := len(targets) - currentPage.offset; n < perPage {
+			y := n + 1
+			if v.showInfoLine {
+				y = 2*n + 1
 			}
+			msg := fmt.Sprintf("(%d more lines, type a query to filter)", len(v.bufferLines())-len(targets))
+			v.printTB(0, y, v.config.Style.Basic.fg, v.config.Style.Basic.bg, msg)
+		}
+	}
 
-			m := matches[len(matches)-1]
-			if m[0] > index {
-				printTB(prev, n, v.config.Style.Query.fg, bgAttr|v.config.Style.Query.bg, line[m[0]:m[1]])
-			} else if len(line) > m[1] {
-				printTB(prev, n, fgAttr, bgAttr, line[m[1]:len(line)])
+	if v.IsInputEmpty() {
+		for n, msg := range v.emptyInputMessage() {
+			if n+1 >= height-1 {
+				break
 			}
+			v.printTB(0, n+1, v.config.Style.Basic.fg, v.config.Style.Basic.bg, msg)
 		}
 	}
 
+	if v.showPreview {
+		v.drawPreview(width, height, targets)
+	}
+
+	if v.showOutputPreview {
+		style := v.config.Style.OutputPreview
+		v.printTB(0, v.outputPreviewRow(), style.fg, style.bg, "Output: "+v.previewOutput())
+	}
+
 	if err := termbox.Flush(); err != nil {
 		return
 	}
@@ -0,0 +1,60 @@
+package peco
+
+import (
+	"testing"
+
+	"github.com/mattn/go-runewidth"
+)
+
+func TestScrollQuery(t *testing.T) {
+	const width, margin = 10, 2
+	query := []rune("検索abcｄｅｆghi日本語テスト")
+	cells := func(rs []rune) int {
+		return runewidth.StringWidth(string(rs))
+	}
+
+	// move the caret to the end and back, as if typing and going back
+	offset := 0
+	positions := []int{}
+	for pos := 0; pos <= len(query); pos++ {
+		positions = append(positions, pos)
+	}
+	for pos := len(query) - 1; pos >= 0; pos-- {
+		positions = append(positions, pos)
+	}
+
+	for _, pos := range positions {
+		offset = scrollQuery(query, pos, offset, width, margin)
+		if offset > pos {
+			t.Fatalf("expected the caret at %d to be visible, but the query starts at %d", pos, offset)
+		}
+
+		caretWidth := 1
+		if pos < len(query) {
+			caretWidth = runewidth.RuneWidth(query[pos])
+		}
+		used := cells(query[offset:pos]) + caretWidth
+		if used > width {
+			t.Fatalf("expected the caret at %d to be visible, but it's at cell %d", pos, used)
+		}
+		if offset > 0 && cells(query[offset:pos]) < margin {
+			t.Errorf("expected %d cells before the caret at %d, got %d", margin, pos, cells(query[offset:pos]))
+		}
+		if pos < len(query) {
+			after := cells(query[pos+1:])
+			if after > margin {
+				after = margin
+			}
+			if used+after > width {
+				t.Errorf("expected %d cells after the caret at %d to be visible", after, pos)
+			}
+		}
+	}
+
+	if offset := scrollQuery([]rune("short"), 3, 0, width, margin); offset != 0 {
+		t.Errorf("expected a query that fits to not be scrolled, got %d", offset)
+	}
+	if offset := scrollQuery(query, len(query), 5, width, margin); cells(query[offset:])+1 > width {
+		t.Errorf("expected the end of the query to be visible, got %d", offset)
+	}
+}